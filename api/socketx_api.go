@@ -1,43 +1,375 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/civet148/gotools/parser"
 	"github.com/civet148/log"
 	"github.com/civet148/socketx/types"
+	"github.com/gin-gonic/gin"
 	"net/http"
+	"os"
+	"time"
 )
 
+var ErrHeartbeatNotSupported = errors.New("heartbeat not supported")
+var ErrMessageTooLarge = errors.New("message too large") //the message is dropped, the socket is still usable
+var ErrBadMessage = errors.New("bad message")            //the malformed message is dropped, the socket is still usable
+
 type SocketOption struct {
-	CertFile string
-	KeyFile  string
-	Header   http.Header
+	CertFile      string
+	KeyFile       string
+	Header        http.Header
+	CAFile        string             //TLS CA bundle to verify peer certificate (client: server CA, server: client CA)
+	ServerName    string             //TLS server name for client to verify server certificate, default host of url
+	TLSMinVersion uint16             //TLS min version, default tls.VersionTLS12
+	CipherSuites  []uint16           //TLS cipher suites (TLS 1.0-1.2), nil means default
+	ClientAuth    tls.ClientAuthType //TLS server side client certificate policy, eg. tls.VerifyClientCertIfGiven/tls.RequireAndVerifyClientCert
+	PinnedCerts   []string           //TLS pinned SHA-256 fingerprints (hex) of peer certificate, any certificate in verified chain matches (leaf only if Insecure)
+	PinnedSPKI    []string           //TLS pinned SHA-256 fingerprints (hex) of peer certificate SubjectPublicKeyInfo
+	Insecure      bool               //TLS client skips certificate verification (pins still checked), for test only
+	TLSConfig     *tls.Config        //TLS config overrides all TLS options above, except the server name of client defaults to ServerName or host of url
+	Framer        Framer             //message framer for TCP/UNIX stream socket, overrides url query 'framer'
+	Reconnect     *ReconnectOption   //auto reconnect for SocketClient (TCP/UNIX/WebSocket), nil means disabled
+	Heartbeat     *HeartbeatOption   //heartbeat and dead peer detection, nil means disabled
+	GinRouter     gin.IRoutes        //web socket server mounts the url path on an existing gin engine/group instead of listening
+	ServeMux      *http.ServeMux     //web socket server mounts the url path on an existing http.ServeMux instead of listening
+	Detached      bool               //web socket server does not listen, mount SocketServer.Handler() by yourself
+	UDPSession    *UDPSessionOption  //UDP server session mode, each remote address becomes a SocketClient, nil means disabled
+	UDPFragment   *UDPFragmentOption //UDP fragmentation of large messages, nil means disabled
+	UnixFile      *UnixFileOption    //UNIX listening socket file mode/owner/group and lock file, nil means default
+	Broadcast     *BroadcastOption   //SocketServer broadcast queue of each client, nil means default
+	Metrics       Metrics            //metrics recorder of SocketServer/SocketClient (eg. metrics.NewRegistry()), nil means disabled
+	RateLimit     *RateLimitOption   //SocketServer token bucket rate limits of inbound and outbound messages, nil means unlimited
+	Admission     *AdmissionOption   //SocketServer connection admission control, nil means all connections accepted
+}
+
+// ReconnectOption auto reconnect option of SocketClient, the zero value fields use default values
+type ReconnectOption struct {
+	MinBackoff     time.Duration   //delay before first reconnect attempt, default 1s
+	MaxBackoff     time.Duration   //max delay between reconnect attempts, default 30s
+	Factor         float64         //backoff multiplier, default 2
+	Jitter         float64         //random jitter ratio of delay in [0, 1], default 0.2
+	MaxAttempts    int             //max reconnect attempts for one disconnection, 0 means unlimited
+	QueueSize      int             //max messages queued while disconnected, 0 means send fail fast
+	OnDisconnected func(err error) //called when connection lost
+	OnReconnected  func(s Socket)  //called with the new socket before queued messages are flushed, eg. login or resubscribe
+}
+
+// HeartbeatOption heartbeat option of SocketServer/SocketClient, WebSocket uses native ping/pong frames,
+// TCP/UNIX use application level ping/pong messages which require a framer (both peers must enable heartbeat)
+type HeartbeatOption struct {
+	Interval  time.Duration //ping interval, 0 means only respond to the ping of peer
+	MaxMissed int           //close peer after N heartbeats missed, default 3
+	PingData  []byte        //application level ping message for TCP/UNIX, default types.HEARTBEAT_PING
+	PongData  []byte        //application level pong message for TCP/UNIX, default types.HEARTBEAT_PONG
+	KeepAlive time.Duration //OS level TCP keepalive period, 0 means system default, negative means disabled
+}
+
+// UDPSessionOption UDP server session mode option, OnAccept is called on the first datagram of a remote address
+// and OnClose after the session idle timeout, the zero value fields use default values
+type UDPSessionOption struct {
+	IdleTimeout time.Duration //close session after no datagram received or sent, default 60s
+	QueueSize   int           //max datagrams queued per session, the extra datagrams are dropped, default 128
+}
+
+// UDPFragmentOption UDP fragmentation option, a message is sent as numbered fragments no larger than MTU and
+// reassembled by receiver, both peers must enable it (datagrams without fragment header are dropped as api.ErrBadMessage).
+// The zero value fields use default values
+type UDPFragmentOption struct {
+	MTU        int           //max datagram size including fragment header, default 1400 (must be the same on both peers)
+	MaxMessage int           //max message size, default 1MB
+	MaxMemory  int           //max total size of incomplete messages, the extra fragments are dropped, default 16MB
+	Timeout    time.Duration //drop incomplete message after timeout, default 5s
+}
+
+// UnixFileOption UNIX listening socket file option (not for abstract name), the zero value fields use default values.
+// A stale socket file is removed before listening only if no server accepts on it, a non-socket file is never removed
+type UnixFileOption struct {
+	Mode     os.FileMode //socket file mode, eg. 0660, default by umask
+	Owner    string      //socket file owner, user name or uid, default current user
+	Group    string      //socket file group, group name or gid, default current group
+	LockFile bool        //lock file <path>.lock exclusively while listening (linux), another server on the same path fails fast
+}
+
+// BroadcastOption broadcast option of SocketServer, each client has a queue sent by its own goroutine so a slow client
+// does not block the others, the zero value fields use default values
+type BroadcastOption struct {
+	QueueSize int  //max broadcast messages queued per client, default 256
+	CloseSlow bool //close the client whose queue is full, default false (the message is dropped for the client)
+}
+
+// RateLimit token bucket limit of messages and bytes per second, the zero value fields mean unlimited
+type RateLimit struct {
+	Messages  float64 //messages per second, 0 means unlimited
+	Bytes     float64 //bytes per second, 0 means unlimited
+	MsgBurst  int     //max messages in a burst, default Messages (at least 1)
+	ByteBurst int     //max bytes in a burst, default Bytes (a message larger than burst is allowed when the bucket is full)
+}
+
+// RateLimitOption rate limits of SocketServer on inbound (client to server) and outbound (server to client) messages,
+// a message must pass both limits of its client and the server
+type RateLimitOption struct {
+	ClientIn  *RateLimit            //inbound limit of each client, nil means unlimited
+	ClientOut *RateLimit            //outbound limit of each client, nil means unlimited
+	ServerIn  *RateLimit            //inbound limit shared by all clients, nil means unlimited
+	ServerOut *RateLimit            //outbound limit shared by all clients, nil means unlimited
+	Policy    types.RateLimitPolicy //policy of the message exceeded limit, default types.RateLimitPolicy_Delay
+	MaxDelay  time.Duration         //max delay of a message by types.RateLimitPolicy_Delay (dropped if it needs a longer delay), default 1s
+}
+
+// AdmissionOption connection admission control of SocketServer, the connection rejected is closed before OnAccept,
+// the source IP checks are skipped for the connection without IP address (eg. UNIX socket). Listen returns an error if
+// any CIDR or IP of Allow/Deny is invalid
+type AdmissionOption struct {
+	MaxConns      int      //max connections, 0 means unlimited
+	MaxConnsPerIP int      //max connections of each source IP (or network of IPv4Prefix/IPv6Prefix), 0 means unlimited
+	IPv4Prefix    int      //prefix length of IPv4 network counted by MaxConnsPerIP, default 32 (each IP)
+	IPv6Prefix    int      //prefix length of IPv6 network counted by MaxConnsPerIP, default 128 (each IP), eg. 64
+	Allow         []string //CIDR or IP allow list, empty means all allowed (SocketServer.SetAllowList at runtime)
+	Deny          []string //CIDR or IP deny list checked before allow list (SocketServer.SetDenyList at runtime)
+	AcceptRate    float64  //connections accepted per second, 0 means unlimited
+	AcceptBurst   int      //max connections accepted in a burst, default AcceptRate (at least 1)
+}
+
+// Metrics records the metrics of SocketServer/SocketClient labelled by socket type, the implementation must be
+// safe for concurrent use (see package metrics for the built-in Prometheus exporter)
+type Metrics interface {
+	ConnAccepted(st types.SocketType)                    // connection accepted by server or established by client
+	ConnClosed(st types.SocketType)                      // connection closed
+	ConnRejected(st types.SocketType)                    // connection rejected by server admission control
+	MessageIn(st types.SocketType, bytes int)            // message received
+	MessageOut(st types.SocketType, bytes int)           // message sent
+	RecvError(st types.SocketType)                       // message dropped or read error (not counted for connection closed normally)
+	SendError(st types.SocketType)                       // send error
+	HandlerLatency(st types.SocketType, d time.Duration) // OnReceive handler duration
+}
+
+// Handshaker is implemented by the socket which needs a handshake after accepted (eg. TLS), the server
+// calls Handshake before OnAccept
+type Handshaker interface {
+	Handshake(ctx context.Context) (err error)
+}
+
+// Pinger is implemented by the socket which supports heartbeat
+type Pinger interface {
+	Ping() (err error)                            // send a heartbeat ping to peer, ErrHeartbeatNotSupported returned if not supported
+	GetPong() (last time.Time, rtt time.Duration) // last pong received time and the round trip time
+}
+
+// MessageSender is implemented by the socket which supports message types (web socket)
+type MessageSender interface {
+	SendMessage(msgType int, data []byte) (n int, err error) // send with types.MESSAGE_TYPE_XXX, 0 means binary
+}
+
+// UnreliableSender is implemented by the socket which supports both reliable and unreliable messages (RUDP),
+// Send is reliable and ordered, SendUnreliable may be lost, duplicated or reordered
+type UnreliableSender interface {
+	SendUnreliable(data []byte) (n int, err error)
+}
+
+// FileSender is implemented by the socket which can send open files with a message (UNIX stream socket, linux)
+type FileSender interface {
+	SendFiles(data []byte, files ...*os.File) (n int, err error) // the files can be closed by caller after sent
+}
+
+// PeerCredential process credential of the peer connected
+type PeerCredential struct {
+	PID int    //process id
+	UID uint32 //user id
+	GID uint32 //group id
+}
+
+// CredentialSocket is implemented by the socket which can get the credential of peer process (UNIX socket, linux)
+type CredentialSocket interface {
+	GetPeerCredential() (cred *PeerCredential, err error)
 }
 
 type SockMessage struct {
-	Sock    Socket //socket handle
-	Data    []byte //data received
-	From    string //remote address for UDP
-	MsgType int    //only for websocket
+	Sock    Socket     //socket handle
+	Data    []byte     //data received
+	From    string     //remote address for UDP
+	MsgType int        //only for websocket, types.MESSAGE_TYPE_TEXT or types.MESSAGE_TYPE_BINARY
+	Files   []*os.File //open files received with the message (UNIX socket, linux), the receiver should close them
 }
 
 type Socket interface {
-	Listen() (err error)                                     // bind and listen on address and port
-	Accept() Socket                                          // accept connection...
-	Connect() (err error)                                    // for tcp/web socket
-	Send(data []byte, to ...string) (n int, err error)       // send to...
-	SendJson(v interface{}, to ...string) (n int, err error) // send json to...
-	Recv(length int) (msg *SockMessage, err error)           // receive from... if length > 0, will receive the bytes specified.
-	Close() (err error)                                      // close socket
-	GetLocalAddr() string                                    // get socket local address
-	GetRemoteAddr() string                                   // get socket remote address
-	GetSocketType() types.SocketType                         // get socket type
+	Listen() (err error)                                                           // bind and listen on address and port
+	Accept() Socket                                                                // accept connection...
+	Connect() (err error)                                                          // for tcp/web socket
+	Send(data []byte, to ...string) (n int, err error)                             // send to...
+	SendJson(v interface{}, to ...string) (n int, err error)                       // send json to...
+	Recv(length int) (msg *SockMessage, err error)                                 // receive from... if length > 0, will receive the bytes specified (ignored when a framer is used).
+	ConnectContext(ctx context.Context) (err error)                                // connect with context deadline and cancellation
+	SendContext(ctx context.Context, data []byte, to ...string) (n int, err error) // send with context deadline and cancellation
+	RecvContext(ctx context.Context, length int) (msg *SockMessage, err error)     // receive with context deadline and cancellation
+	Close() (err error)                                                            // close socket
+	GetLocalAddr() string                                                          // get socket local address
+	GetRemoteAddr() string                                                         // get socket remote address
+	GetSocketType() types.SocketType                                               // get socket type
 }
 
 type SocketInstance func(ui *parser.UrlInfo, options ...SocketOption) Socket
 
 var instances = make(map[types.SocketType]SocketInstance)
 
+func (o *HeartbeatOption) GetMaxMissed() int {
+	if o.MaxMissed <= 0 {
+		return 3
+	}
+	return o.MaxMissed
+}
+
+func (o *HeartbeatOption) GetPingData() []byte {
+	if len(o.PingData) == 0 {
+		return []byte(types.HEARTBEAT_PING)
+	}
+	return o.PingData
+}
+
+func (o *HeartbeatOption) GetPongData() []byte {
+	if len(o.PongData) == 0 {
+		return []byte(types.HEARTBEAT_PONG)
+	}
+	return o.PongData
+}
+
+// GetHeartbeat returns heartbeat option, nil if disabled
+func GetHeartbeat(options ...SocketOption) *HeartbeatOption {
+	if len(options) == 0 {
+		return nil
+	}
+	return options[0].Heartbeat
+}
+
+// GetUDPSession returns UDP session option, nil if disabled
+func GetUDPSession(options ...SocketOption) *UDPSessionOption {
+	if len(options) == 0 {
+		return nil
+	}
+	return options[0].UDPSession
+}
+
+func (o *UDPSessionOption) GetIdleTimeout() time.Duration {
+	if o.IdleTimeout <= 0 {
+		return 60 * time.Second
+	}
+	return o.IdleTimeout
+}
+
+func (o *UDPSessionOption) GetQueueSize() int {
+	if o.QueueSize <= 0 {
+		return 128
+	}
+	return o.QueueSize
+}
+
+// GetUDPFragment returns UDP fragmentation option, nil if disabled
+func GetUDPFragment(options ...SocketOption) *UDPFragmentOption {
+	if len(options) == 0 {
+		return nil
+	}
+	return options[0].UDPFragment
+}
+
+func (o *UDPFragmentOption) GetMTU() int {
+	if o.MTU <= 0 {
+		return 1400
+	}
+	return o.MTU
+}
+
+func (o *UDPFragmentOption) GetMaxMessage() int {
+	if o.MaxMessage <= 0 {
+		return 1024 * 1024
+	}
+	return o.MaxMessage
+}
+
+func (o *UDPFragmentOption) GetMaxMemory() int {
+	if o.MaxMemory <= 0 {
+		return 16 * 1024 * 1024
+	}
+	return o.MaxMemory
+}
+
+func (o *UDPFragmentOption) GetTimeout() time.Duration {
+	if o.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return o.Timeout
+}
+
+// GetUnixFile returns UNIX socket file option, nil if not set
+func GetUnixFile(options ...SocketOption) *UnixFileOption {
+	if len(options) == 0 {
+		return nil
+	}
+	return options[0].UnixFile
+}
+
+// GetMetrics returns metrics recorder, nil if not set
+func GetMetrics(options ...SocketOption) Metrics {
+	if len(options) == 0 {
+		return nil
+	}
+	return options[0].Metrics
+}
+
+// GetRateLimit returns rate limit option, nil if not set
+func GetRateLimit(options ...SocketOption) *RateLimitOption {
+	if len(options) == 0 {
+		return nil
+	}
+	return options[0].RateLimit
+}
+
+// GetAdmission returns admission option, nil if not set
+func GetAdmission(options ...SocketOption) *AdmissionOption {
+	if len(options) == 0 {
+		return nil
+	}
+	return options[0].Admission
+}
+
+func (o *RateLimitOption) GetMaxDelay() time.Duration {
+	if o.MaxDelay <= 0 {
+		return time.Second
+	}
+	return o.MaxDelay
+}
+
+func (o *AdmissionOption) GetIPv4Prefix() int {
+	if o.IPv4Prefix <= 0 || o.IPv4Prefix > 32 {
+		return 32
+	}
+	return o.IPv4Prefix
+}
+
+func (o *AdmissionOption) GetIPv6Prefix() int {
+	if o.IPv6Prefix <= 0 || o.IPv6Prefix > 128 {
+		return 128
+	}
+	return o.IPv6Prefix
+}
+
+// GetBroadcast returns broadcast option, the default option returned if not set
+func GetBroadcast(options ...SocketOption) *BroadcastOption {
+	if len(options) == 0 || options[0].Broadcast == nil {
+		return &BroadcastOption{}
+	}
+	return options[0].Broadcast
+}
+
+func (o *BroadcastOption) GetQueueSize() int {
+	if o.QueueSize <= 0 {
+		return 256
+	}
+	return o.QueueSize
+}
+
 func Register(sockType types.SocketType, inst SocketInstance) (err error) {
 	if _, ok := instances[sockType]; !ok {
 
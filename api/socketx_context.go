@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TimeoutError returned by XxxContext methods when the context deadline exceeded or the context canceled
+type TimeoutError struct {
+	Op  string // operation, eg. connect/send/recv
+	Err error  // context.DeadlineExceeded or context.Canceled
+}
+
+func NewTimeoutError(op string, err error) *TimeoutError {
+	return &TimeoutError{
+		Op:  op,
+		Err: err,
+	}
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout [%v]", e.Op, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout implements net.Error
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary implements net.Error
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+// IsTimeout reports whether err is a *TimeoutError
+func IsTimeout(err error) bool {
+	var e *TimeoutError
+	return errors.As(err, &e)
+}
+
+// RunContext call fn with the connection deadline bound to ctx, the deadline is set to ctx.Deadline() before fn
+// and set to the past when ctx canceled, so that a blocking read/write returns immediately.
+// The deadline is cleared after fn returned. A *TimeoutError is returned if fn failed because of ctx.
+func RunContext(ctx context.Context, op string, setDeadline func(t time.Time) error, fn func() error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return NewTimeoutError(op, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if ok {
+		if err = setDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	var stop, done chan struct{}
+	if ctx.Done() != nil {
+		stop = make(chan struct{})
+		done = make(chan struct{})
+		go func() {
+			defer close(done)
+			select {
+			case <-ctx.Done():
+				_ = setDeadline(time.Unix(1, 0)) //unblock read/write immediately
+			case <-stop:
+			}
+		}()
+	}
+	err = fn()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	_ = setDeadline(time.Time{})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return NewTimeoutError(op, ctxErr)
+		}
+		if ok && !time.Now().Before(deadline) {
+			return NewTimeoutError(op, context.DeadlineExceeded)
+		}
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// RouteExtractor extracts the route key (message type) and the payload from a message
+type RouteExtractor interface {
+	Extract(data []byte) (key string, payload []byte, err error)
+}
+
+// JSONFieldExtractor extracts the route key from a top level field (string or number) of a JSON object,
+// eg. {"type":"login","data":{...}}
+type JSONFieldExtractor struct {
+	Field        string // route key field name
+	PayloadField string // payload field name, empty means the whole message is the payload
+}
+
+// PrefixExtractor extracts the first N bytes as the route key, the rest is the payload
+type PrefixExtractor struct {
+	Size int // route key size
+}
+
+// HeaderExtractor extracts an unsigned integer header (1/2/4/8 bytes) as the route key in decimal, eg. "1001",
+// the rest is the payload
+type HeaderExtractor struct {
+	Size  int              // header size, 1/2/4/8 bytes
+	Order binary.ByteOrder // header byte order, nil means big endian
+}
+
+func NewJSONFieldExtractor(field, payloadField string) *JSONFieldExtractor {
+	return &JSONFieldExtractor{
+		Field:        field,
+		PayloadField: payloadField,
+	}
+}
+
+func NewPrefixExtractor(size int) *PrefixExtractor {
+	return &PrefixExtractor{
+		Size: size,
+	}
+}
+
+func NewHeaderExtractor(size int, order binary.ByteOrder) *HeaderExtractor {
+	return &HeaderExtractor{
+		Size:  size,
+		Order: order,
+	}
+}
+
+func (e *JSONFieldExtractor) Extract(data []byte) (key string, payload []byte, err error) {
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return "", nil, fmt.Errorf("extract field [%s] error [%s]", e.Field, err.Error())
+	}
+	raw, ok := fields[e.Field]
+	if !ok {
+		return "", nil, fmt.Errorf("extract field [%s] not found", e.Field)
+	}
+	if err = json.Unmarshal(raw, &key); err != nil { //number or other literal
+		key = string(bytes.TrimSpace(raw))
+		err = nil
+	}
+	payload = data
+	if e.PayloadField != "" {
+		payload = fields[e.PayloadField]
+	}
+	return
+}
+
+func (e *PrefixExtractor) Extract(data []byte) (key string, payload []byte, err error) {
+	if e.Size <= 0 || len(data) < e.Size {
+		return "", nil, fmt.Errorf("extract prefix [%d] bytes from message length [%d]", e.Size, len(data))
+	}
+	return string(data[:e.Size]), data[e.Size:], nil
+}
+
+func (e *HeaderExtractor) Extract(data []byte) (key string, payload []byte, err error) {
+	if len(data) < e.Size {
+		return "", nil, fmt.Errorf("extract header [%d] bytes from message length [%d]", e.Size, len(data))
+	}
+	order := e.Order
+	if order == nil {
+		order = binary.BigEndian
+	}
+	var id uint64
+	switch e.Size {
+	case 1:
+		id = uint64(data[0])
+	case 2:
+		id = uint64(order.Uint16(data))
+	case 4:
+		id = uint64(order.Uint32(data))
+	case 8:
+		id = order.Uint64(data)
+	default:
+		return "", nil, fmt.Errorf("header size [%d] not supported, 1/2/4/8 bytes", e.Size)
+	}
+	return strconv.FormatUint(id, 10), data[e.Size:], nil
+}
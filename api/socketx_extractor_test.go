@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestRouteExtractor(t *testing.T) {
+	cases := []struct {
+		name      string
+		extractor RouteExtractor
+		data      []byte
+		key       string
+		payload   string
+		ok        bool
+	}{
+		{"json string", NewJSONFieldExtractor("type", ""), []byte(`{"type":"login","id":1}`), "login", `{"type":"login","id":1}`, true},
+		{"json number", NewJSONFieldExtractor("type", "data"), []byte(`{"type":1001,"data":{"id":1}}`), "1001", `{"id":1}`, true},
+		{"json no payload field", NewJSONFieldExtractor("type", "data"), []byte(`{"type":"ping"}`), "ping", "", true},
+		{"json field not found", NewJSONFieldExtractor("type", ""), []byte(`{"kind":"login"}`), "", "", false},
+		{"json invalid", NewJSONFieldExtractor("type", ""), []byte(`login`), "", "", false},
+		{"prefix", NewPrefixExtractor(2), []byte("LGalice"), "LG", "alice", true},
+		{"prefix short", NewPrefixExtractor(4), []byte("LG"), "", "", false},
+		{"header 1", NewHeaderExtractor(1, nil), []byte{7, 'a'}, "7", "a", true},
+		{"header 2", NewHeaderExtractor(2, nil), []byte{0x03, 0xE9, 'a'}, "1001", "a", true},
+		{"header 4 le", NewHeaderExtractor(4, binary.LittleEndian), []byte{0xE9, 0x03, 0, 0, 'a'}, "1001", "a", true},
+		{"header 8", NewHeaderExtractor(8, nil), []byte{0, 0, 0, 0, 0, 0, 0x03, 0xE9}, "1001", "", true},
+		{"header short", NewHeaderExtractor(4, nil), []byte{1, 2}, "", "", false},
+		{"header size not supported", NewHeaderExtractor(3, nil), []byte{1, 2, 3}, "", "", false},
+	}
+	for _, c := range cases {
+		key, payload, err := c.extractor.Extract(c.data)
+		if (err == nil) != c.ok {
+			t.Fatalf("%s: expect ok %v, got error %v", c.name, c.ok, err)
+		}
+		if key != c.key || string(payload) != c.payload {
+			t.Fatalf("%s: extract key [%s] payload [%s], want [%s] [%s]", c.name, key, payload, c.key, c.payload)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/civet148/gotools/parser"
+	"github.com/civet148/socketx/types"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Framer splits a byte stream (TCP/UNIX) into application messages
+type Framer interface {
+	Name() string                                    // framer name
+	Encode(data []byte) (frame []byte, err error)    // build one framed message from data
+	Decode(r *bufio.Reader) (data []byte, err error) // read exactly one message from stream
+}
+
+// LengthFramer prefixes each message with a fixed size (2/4/8 bytes) length header
+type LengthFramer struct {
+	Size     int              // length header size, 2/4/8 bytes
+	Order    binary.ByteOrder // length header byte order
+	MaxFrame int              // max message length, <= 0 means types.TCP_FRAGMENT_MAX
+}
+
+// UvarintFramer prefixes each message with an unsigned varint length header
+type UvarintFramer struct {
+	MaxFrame int // max message length, <= 0 means types.TCP_FRAGMENT_MAX
+}
+
+// DelimiterFramer terminates each message with a delimiter, eg. "\n" "\r\n" or "\x00"
+type DelimiterFramer struct {
+	Delimiter []byte // message delimiter
+	MaxFrame  int    // max message length, <= 0 means types.TCP_FRAGMENT_MAX
+}
+
+// FixedFramer reads and writes messages of a fixed size
+type FixedFramer struct {
+	Size int // message size
+}
+
+func NewLengthFramer(size int, order binary.ByteOrder) *LengthFramer {
+	return &LengthFramer{
+		Size:  size,
+		Order: order,
+	}
+}
+
+func NewUvarintFramer() *UvarintFramer {
+	return &UvarintFramer{}
+}
+
+func NewDelimiterFramer(delimiter []byte) *DelimiterFramer {
+	return &DelimiterFramer{
+		Delimiter: delimiter,
+	}
+}
+
+func NewFixedFramer(size int) *FixedFramer {
+	return &FixedFramer{
+		Size: size,
+	}
+}
+
+// NewFramer create a built-in framer by name (see types.FRAMER_XXX), size only for fixed framer
+func NewFramer(name string, size int) (f Framer, err error) {
+	switch strings.ToLower(name) {
+	case types.FRAMER_LEN2:
+		f = NewLengthFramer(2, binary.BigEndian)
+	case types.FRAMER_LEN4:
+		f = NewLengthFramer(4, binary.BigEndian)
+	case types.FRAMER_LEN8:
+		f = NewLengthFramer(8, binary.BigEndian)
+	case types.FRAMER_LEN2_LE:
+		f = NewLengthFramer(2, binary.LittleEndian)
+	case types.FRAMER_LEN4_LE:
+		f = NewLengthFramer(4, binary.LittleEndian)
+	case types.FRAMER_LEN8_LE:
+		f = NewLengthFramer(8, binary.LittleEndian)
+	case types.FRAMER_UVARINT:
+		f = NewUvarintFramer()
+	case types.FRAMER_LF:
+		f = NewDelimiterFramer([]byte("\n"))
+	case types.FRAMER_CRLF:
+		f = NewDelimiterFramer([]byte("\r\n"))
+	case types.FRAMER_NUL:
+		f = NewDelimiterFramer([]byte{0})
+	case types.FRAMER_FIXED:
+		if size <= 0 {
+			return nil, fmt.Errorf("fixed framer size must be greater than 0")
+		}
+		f = NewFixedFramer(size)
+	default:
+		return nil, fmt.Errorf("unknown framer [%s]", name)
+	}
+	return
+}
+
+// GetFramer returns the framer from socket option or url queries (eg. tcp://127.0.0.1:6666?framer=len4), nil if no framer specified
+func GetFramer(ui *parser.UrlInfo, options ...SocketOption) (f Framer, err error) {
+	if len(options) != 0 && options[0].Framer != nil {
+		return options[0].Framer, nil
+	}
+	if ui == nil {
+		return
+	}
+	name := ui.Queries[types.URL_QUERY_FRAMER]
+	if name == "" {
+		return
+	}
+	var size int
+	if strSize := ui.Queries[types.URL_QUERY_FRAMER_SIZE]; strSize != "" {
+		if size, err = strconv.Atoi(strSize); err != nil {
+			return nil, fmt.Errorf("framer size [%s] invalid", strSize)
+		}
+	}
+	return NewFramer(name, size)
+}
+
+func (f *LengthFramer) Name() string {
+	var name string
+	switch f.Size {
+	case 2:
+		name = types.FRAMER_LEN2
+	case 4:
+		name = types.FRAMER_LEN4
+	case 8:
+		name = types.FRAMER_LEN8
+	default:
+		return fmt.Sprintf("len%d", f.Size)
+	}
+	if f.Order == binary.LittleEndian {
+		name += "le"
+	}
+	return name
+}
+
+func (f *LengthFramer) Encode(data []byte) (frame []byte, err error) {
+	length := len(data)
+	if length > maxFrame(f.MaxFrame) {
+		return nil, fmt.Errorf("message length %d exceeds max frame %d", length, maxFrame(f.MaxFrame))
+	}
+	frame = make([]byte, f.Size+length)
+	switch f.Size {
+	case 2:
+		if length > 0xFFFF {
+			return nil, fmt.Errorf("message length %d overflow 2 bytes header", length)
+		}
+		f.Order.PutUint16(frame, uint16(length))
+	case 4:
+		f.Order.PutUint32(frame, uint32(length))
+	case 8:
+		f.Order.PutUint64(frame, uint64(length))
+	default:
+		return nil, fmt.Errorf("length header size %d not supported", f.Size)
+	}
+	copy(frame[f.Size:], data)
+	return
+}
+
+func (f *LengthFramer) Decode(r *bufio.Reader) (data []byte, err error) {
+	var length uint64
+	header := make([]byte, f.Size)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	switch f.Size {
+	case 2:
+		length = uint64(f.Order.Uint16(header))
+	case 4:
+		length = uint64(f.Order.Uint32(header))
+	case 8:
+		length = f.Order.Uint64(header)
+	default:
+		return nil, fmt.Errorf("length header size %d not supported", f.Size)
+	}
+	return readFrame(r, length, f.MaxFrame)
+}
+
+func (f *UvarintFramer) Name() string {
+	return types.FRAMER_UVARINT
+}
+
+func (f *UvarintFramer) Encode(data []byte) (frame []byte, err error) {
+	length := len(data)
+	if length > maxFrame(f.MaxFrame) {
+		return nil, fmt.Errorf("message length %d exceeds max frame %d", length, maxFrame(f.MaxFrame))
+	}
+	header := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(header, uint64(length))
+	frame = make([]byte, n+length)
+	copy(frame, header[:n])
+	copy(frame[n:], data)
+	return
+}
+
+func (f *UvarintFramer) Decode(r *bufio.Reader) (data []byte, err error) {
+	var length uint64
+	if length, err = binary.ReadUvarint(r); err != nil {
+		return nil, err
+	}
+	return readFrame(r, length, f.MaxFrame)
+}
+
+func (f *DelimiterFramer) Name() string {
+	switch string(f.Delimiter) {
+	case "\n":
+		return types.FRAMER_LF
+	case "\r\n":
+		return types.FRAMER_CRLF
+	case "\x00":
+		return types.FRAMER_NUL
+	}
+	return fmt.Sprintf("delimiter%q", f.Delimiter)
+}
+
+func (f *DelimiterFramer) Encode(data []byte) (frame []byte, err error) {
+	if len(f.Delimiter) == 0 {
+		return nil, fmt.Errorf("delimiter is empty")
+	}
+	if bytes.Contains(data, f.Delimiter) {
+		return nil, fmt.Errorf("message contains delimiter %q", f.Delimiter)
+	}
+	if len(data) > maxFrame(f.MaxFrame) {
+		return nil, fmt.Errorf("message length %d exceeds max frame %d", len(data), maxFrame(f.MaxFrame))
+	}
+	frame = make([]byte, 0, len(data)+len(f.Delimiter))
+	frame = append(frame, data...)
+	frame = append(frame, f.Delimiter...)
+	return
+}
+
+func (f *DelimiterFramer) Decode(r *bufio.Reader) (data []byte, err error) {
+	if len(f.Delimiter) == 0 {
+		return nil, fmt.Errorf("delimiter is empty")
+	}
+	last := f.Delimiter[len(f.Delimiter)-1]
+	for {
+		var line []byte
+		line, err = r.ReadSlice(last)
+		if err != nil && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		data = append(data, line...)
+		if len(data) > maxFrame(f.MaxFrame)+len(f.Delimiter) {
+			return nil, fmt.Errorf("message length exceeds max frame %d", maxFrame(f.MaxFrame))
+		}
+		if err == nil && bytes.HasSuffix(data, f.Delimiter) {
+			return data[:len(data)-len(f.Delimiter)], nil
+		}
+	}
+}
+
+func (f *FixedFramer) Name() string {
+	return types.FRAMER_FIXED
+}
+
+func (f *FixedFramer) Encode(data []byte) (frame []byte, err error) {
+	if len(data) != f.Size {
+		return nil, fmt.Errorf("message length %d not equal to fixed size %d", len(data), f.Size)
+	}
+	return data, nil
+}
+
+func (f *FixedFramer) Decode(r *bufio.Reader) (data []byte, err error) {
+	return readFrame(r, uint64(f.Size), f.Size)
+}
+
+func readFrame(r *bufio.Reader, length uint64, max int) (data []byte, err error) {
+	if length > uint64(maxFrame(max)) {
+		return nil, fmt.Errorf("message length %d exceeds max frame %d", length, maxFrame(max))
+	}
+	data = make([]byte, length)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func maxFrame(max int) int {
+	if max <= 0 {
+		return types.TCP_FRAGMENT_MAX
+	}
+	return max
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// the messages encoded are decoded back from one stream in order
+func TestFramerRoundTrip(t *testing.T) {
+	messages := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("x"), 1000), []byte("world")}
+	cases := []struct {
+		name     string
+		framer   Framer
+		messages [][]byte
+	}{
+		{"len2", NewLengthFramer(2, binary.BigEndian), messages},
+		{"len4", NewLengthFramer(4, binary.BigEndian), messages},
+		{"len8", NewLengthFramer(8, binary.BigEndian), messages},
+		{"len4le", NewLengthFramer(4, binary.LittleEndian), messages},
+		{"uvarint", NewUvarintFramer(), messages},
+		{"lf", NewDelimiterFramer([]byte("\n")), messages},
+		{"crlf with embedded lf", NewDelimiterFramer([]byte("\r\n")), [][]byte{[]byte("a\nb"), []byte("\n"), []byte("c\r")}},
+		{"nul", NewDelimiterFramer([]byte{0}), messages},
+		{"fixed", NewFixedFramer(3), [][]byte{[]byte("abc"), []byte("def")}},
+	}
+	for _, c := range cases {
+		var stream bytes.Buffer
+		for _, m := range c.messages {
+			frame, err := c.framer.Encode(m)
+			if err != nil {
+				t.Fatalf("%s: encode [%q] error [%s]", c.name, m, err)
+			}
+			stream.Write(frame)
+		}
+		r := bufio.NewReader(&stream)
+		for _, m := range c.messages {
+			data, err := c.framer.Decode(r)
+			if err != nil {
+				t.Fatalf("%s: decode [%q] error [%s]", c.name, m, err)
+			}
+			if !bytes.Equal(data, m) {
+				t.Fatalf("%s: decode [%q], want [%q]", c.name, data, m)
+			}
+		}
+		if _, err := c.framer.Decode(r); err == nil {
+			t.Fatalf("%s: decode from an empty stream succeeded", c.name)
+		}
+	}
+}
+
+// the messages longer than max frame (or the header of length framer) are rejected by Encode and Decode
+func TestFramerMaxFrame(t *testing.T) {
+	cases := []struct {
+		name   string
+		framer Framer
+		length int
+		ok     bool
+	}{
+		{"len4 max", &LengthFramer{Size: 4, Order: binary.BigEndian, MaxFrame: 16}, 16, true},
+		{"len4 over max", &LengthFramer{Size: 4, Order: binary.BigEndian, MaxFrame: 16}, 17, false},
+		{"len2 0xFFFF", &LengthFramer{Size: 2, Order: binary.BigEndian, MaxFrame: 0x20000}, 0xFFFF, true},
+		{"len2 overflow", &LengthFramer{Size: 2, Order: binary.BigEndian, MaxFrame: 0x20000}, 0x10000, false},
+		{"len3 not supported", &LengthFramer{Size: 3, Order: binary.BigEndian}, 1, false},
+		{"uvarint over max", &UvarintFramer{MaxFrame: 16}, 17, false},
+		{"lf over max", &DelimiterFramer{Delimiter: []byte("\n"), MaxFrame: 16}, 17, false},
+		{"fixed not equal", NewFixedFramer(4), 5, false},
+	}
+	for _, c := range cases {
+		if _, err := c.framer.Encode(bytes.Repeat([]byte("x"), c.length)); (err == nil) != c.ok {
+			t.Errorf("%s: encode %d bytes expect ok %v, got error %v", c.name, c.length, c.ok, err)
+		}
+	}
+
+	//the frames of a peer without limit are rejected by the decoder with max frame
+	decoders := []struct {
+		name    string
+		encoder Framer
+		decoder Framer
+	}{
+		{"len4", NewLengthFramer(4, binary.BigEndian), &LengthFramer{Size: 4, Order: binary.BigEndian, MaxFrame: 16}},
+		{"uvarint", NewUvarintFramer(), &UvarintFramer{MaxFrame: 16}},
+		{"lf", NewDelimiterFramer([]byte("\n")), &DelimiterFramer{Delimiter: []byte("\n"), MaxFrame: 16}},
+	}
+	for _, c := range decoders {
+		frame, err := c.encoder.Encode(bytes.Repeat([]byte("x"), 17))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.decoder.Decode(bufio.NewReader(bytes.NewReader(frame))); err == nil {
+			t.Errorf("%s: decode a frame over max succeeded", c.name)
+		}
+	}
+}
+
+func TestDelimiterFramerEncode(t *testing.T) {
+	if _, err := NewDelimiterFramer([]byte("\r\n")).Encode([]byte("a\r\nb")); err == nil {
+		t.Error("encode a message containing the delimiter succeeded")
+	}
+	if _, err := NewDelimiterFramer(nil).Encode([]byte("a")); err == nil {
+		t.Error("encode with an empty delimiter succeeded")
+	}
+}
+
+func TestNewFramer(t *testing.T) {
+	for _, name := range []string{"len2", "len4", "len8", "len2le", "len4le", "len8le", "uvarint", "lf", "crlf", "nul"} {
+		f, err := NewFramer(name, 0)
+		if err != nil {
+			t.Fatalf("new framer [%s] error [%s]", name, err)
+		}
+		if f.Name() != name {
+			t.Errorf("framer [%s] named [%s]", name, f.Name())
+		}
+	}
+	if _, err := NewFramer("fixed", 0); err == nil {
+		t.Error("new fixed framer without size succeeded")
+	}
+	if _, err := NewFramer("unknown", 0); err == nil {
+		t.Error("new unknown framer succeeded")
+	}
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/civet148/gotools/parser"
+	"github.com/civet148/socketx/types"
+	"net"
+	"os"
+	"strings"
+)
+
+// TLSSocket is implemented by the socket over TLS
+type TLSSocket interface {
+	GetTLSState() (state tls.ConnectionState, ok bool) // TLS connection state, ok is false if not a TLS connection
+}
+
+// NewTLSConfig build TLS config by socket option and url queries (cert/key/ca)
+func NewTLSConfig(ui *parser.UrlInfo, server bool, options ...SocketOption) (config *tls.Config, err error) {
+	var opt SocketOption
+	if len(options) != 0 {
+		opt = options[0]
+	}
+	if opt.TLSConfig != nil {
+		config = opt.TLSConfig.Clone()
+		if !server && config.ServerName == "" {
+			config.ServerName = opt.ServerName
+			if config.ServerName == "" && ui != nil {
+				config.ServerName = getServerName(ui.GetHost())
+			}
+		}
+		return config, nil
+	}
+	config = &tls.Config{
+		MinVersion:   opt.TLSMinVersion,
+		CipherSuites: opt.CipherSuites,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	strCertFile, strKeyFile, strCAFile := opt.CertFile, opt.KeyFile, opt.CAFile
+	if ui != nil {
+		if strCertFile == "" {
+			strCertFile = ui.Queries[types.WSS_TLS_CERT]
+		}
+		if strKeyFile == "" {
+			strKeyFile = ui.Queries[types.WSS_TLS_KEY]
+		}
+		if strCAFile == "" {
+			strCAFile = ui.Queries[types.TLS_CA]
+		}
+	}
+	if strCertFile != "" || strKeyFile != "" {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(strCertFile, strKeyFile); err != nil {
+			return nil, fmt.Errorf("load certificate [%s] key [%s] error [%s]", strCertFile, strKeyFile, err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if server {
+		return nil, fmt.Errorf("TLS server certificate and key required")
+	}
+	var pool *x509.CertPool
+	if strCAFile != "" {
+		if pool, err = LoadCertPool(strCAFile); err != nil {
+			return nil, err
+		}
+	}
+	if server {
+		config.ClientCAs = pool
+		config.ClientAuth = opt.ClientAuth
+	} else {
+		config.RootCAs = pool
+		config.ServerName = opt.ServerName
+		config.InsecureSkipVerify = opt.Insecure
+		if config.ServerName == "" && ui != nil {
+			config.ServerName = getServerName(ui.GetHost())
+		}
+	}
+	if len(opt.PinnedCerts) != 0 || len(opt.PinnedSPKI) != 0 {
+		var pins *certPins
+		if pins, err = newCertPins(opt.PinnedCerts, opt.PinnedSPKI); err != nil {
+			return nil, err
+		}
+		config.VerifyPeerCertificate = pins.verify
+	}
+	return
+}
+
+// LoadCertPool load PEM encoded CA certificates from file
+func LoadCertPool(strCAFile string) (pool *x509.CertPool, err error) {
+	var data []byte
+	if data, err = os.ReadFile(strCAFile); err != nil {
+		return nil, fmt.Errorf("read CA file [%s] error [%s]", strCAFile, err.Error())
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in CA file [%s]", strCAFile)
+	}
+	return
+}
+
+// GetPeerCertificate returns the verified peer leaf certificate of TLS state, nil if not verified
+func GetPeerCertificate(state tls.ConnectionState) *x509.Certificate {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// Fingerprint returns the hex SHA-256 fingerprint of data (DER certificate or SubjectPublicKeyInfo)
+func Fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// certPins verify peer certificate chain by pinned fingerprints
+type certPins struct {
+	certs map[string]bool
+	spki  map[string]bool
+}
+
+func newCertPins(certs, spki []string) (pins *certPins, err error) {
+	pins = &certPins{
+		certs: make(map[string]bool),
+		spki:  make(map[string]bool),
+	}
+	for _, v := range certs {
+		var fp string
+		if fp, err = normalizeFingerprint(v); err != nil {
+			return nil, err
+		}
+		pins.certs[fp] = true
+	}
+	for _, v := range spki {
+		var fp string
+		if fp, err = normalizeFingerprint(v); err != nil {
+			return nil, err
+		}
+		pins.spki[fp] = true
+	}
+	return
+}
+
+// verify pins against the verified chains, or the peer leaf certificate only if verification skipped (eg. Insecure),
+// the unverified certificates sent by peer can not be trusted (anyone can append a public certificate pinned)
+func (p *certPins) verify(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) != 0 {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				if p.match(cert) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("peer certificate chain does not match any pinned fingerprint")
+	}
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no peer certificate to verify pinned fingerprint")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("parse peer certificate error [%s]", err.Error())
+	}
+	if p.match(cert) {
+		return nil
+	}
+	return fmt.Errorf("peer certificate does not match any pinned fingerprint")
+}
+
+func (p *certPins) match(cert *x509.Certificate) bool {
+	return p.certs[Fingerprint(cert.Raw)] || p.spki[Fingerprint(cert.RawSubjectPublicKeyInfo)]
+}
+
+// normalizeFingerprint lower case hex SHA-256 fingerprint without colons
+func normalizeFingerprint(fp string) (string, error) {
+	fp = strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+	if b, err := hex.DecodeString(fp); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 fingerprint [%s]", fp)
+	}
+	return fp, nil
+}
+
+func getServerName(host string) string {
+	if host == "" {
+		return "localhost"
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
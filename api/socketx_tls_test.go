@@ -0,0 +1,170 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/civet148/gotools/parser"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert create a certificate signed by parent, self-signed if parent is nil
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	signer, signerKey := tpl, key
+	if parent == nil {
+		tpl.IsCA, tpl.BasicConstraintsValid = true, true
+	} else {
+		tpl.DNSNames = []string{"localhost"}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func writeCAFile(t *testing.T, cas ...*testCert) string {
+	var data []byte
+	for _, ca := range cas {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	}
+	strCAFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(strCAFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return strCAFile
+}
+
+// handshake returns the client handshake error with a server presenting the certificate chain
+func handshake(t *testing.T, opt SocketOption, chain ...*testCert) error {
+	config, err := NewTLSConfig(nil, false, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certificate tls.Certificate
+	for _, c := range chain {
+		certificate.Certificate = append(certificate.Certificate, c.cert.Raw)
+	}
+	certificate.PrivateKey = chain[0].key
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	defer sc.Close()
+	go func() {
+		_ = tls.Server(sc, &tls.Config{Certificates: []tls.Certificate{certificate}}).Handshake()
+		_ = sc.Close()
+	}()
+	return tls.Client(cc, config).Handshake()
+}
+
+func TestPinnedCerts(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	leaf := newTestCert(t, "leaf", ca)
+	rogueCA := newTestCert(t, "rogue ca", nil)
+	rogue := newTestCert(t, "rogue", rogueCA)
+	self := newTestCert(t, "self", nil)
+
+	cases := []struct {
+		name  string
+		opt   SocketOption
+		chain []*testCert
+		ok    bool
+	}{
+		{"pinned CA", SocketOption{CAFile: writeCAFile(t, ca), PinnedCerts: []string{Fingerprint(ca.cert.Raw)}}, []*testCert{leaf, ca}, true},
+		{"pinned leaf SPKI", SocketOption{CAFile: writeCAFile(t, ca), PinnedSPKI: []string{Fingerprint(leaf.cert.RawSubjectPublicKeyInfo)}}, []*testCert{leaf}, true},
+		{"not pinned", SocketOption{CAFile: writeCAFile(t, ca), PinnedCerts: []string{Fingerprint(self.cert.Raw)}}, []*testCert{leaf, ca}, false},
+		//the pinned CA appended is not in the chain verified by the rogue CA
+		{"unverified pinned CA", SocketOption{CAFile: writeCAFile(t, ca, rogueCA), PinnedCerts: []string{Fingerprint(ca.cert.Raw)}}, []*testCert{rogue, ca}, false},
+		{"insecure pinned leaf", SocketOption{Insecure: true, PinnedCerts: []string{Fingerprint(self.cert.Raw)}}, []*testCert{self}, true},
+		//only the leaf is checked without verification, anyone can append the pinned certificate
+		{"insecure pinned appended", SocketOption{Insecure: true, PinnedCerts: []string{Fingerprint(leaf.cert.Raw)}}, []*testCert{self, leaf}, false},
+	}
+	for _, c := range cases {
+		c.opt.ServerName = "localhost"
+		if err := handshake(t, c.opt, c.chain...); (err == nil) != c.ok {
+			t.Errorf("%s: expect ok %v, got error %v", c.name, c.ok, err)
+		}
+	}
+}
+
+// the client config from SocketOption.TLSConfig without a server name verifies the host of url
+func TestTLSConfigServerName(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	cases := []struct {
+		url  string
+		opt  SocketOption
+		want string
+	}{
+		{"tls://localhost:6666", SocketOption{TLSConfig: &tls.Config{RootCAs: pool}}, "localhost"},
+		{"tls://127.0.0.1:6666", SocketOption{TLSConfig: &tls.Config{RootCAs: pool}}, "127.0.0.1"},
+		{"tls://localhost:6666", SocketOption{TLSConfig: &tls.Config{RootCAs: pool}, ServerName: "example.com"}, "example.com"},
+		{"tls://localhost:6666", SocketOption{TLSConfig: &tls.Config{RootCAs: pool, ServerName: "example.org"}}, "example.org"},
+	}
+	for _, c := range cases {
+		config, err := NewTLSConfig(parser.ParseUrl(c.url), false, c.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.ServerName != c.want {
+			t.Errorf("%s: expect server name [%s], got [%s]", c.url, c.want, config.ServerName)
+		}
+		if config.RootCAs != pool {
+			t.Errorf("%s: root CAs of TLSConfig not kept", c.url)
+		}
+	}
+
+	//the handshake verifies the certificate of the host
+	leaf := newTestCert(t, "localhost", ca)
+	opt := SocketOption{TLSConfig: &tls.Config{RootCAs: pool}}
+	config, err := NewTLSConfig(parser.ParseUrl("tls://localhost:6666"), false, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	defer sc.Close()
+	go func() {
+		certificate := tls.Certificate{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key}
+		_ = tls.Server(sc, &tls.Config{Certificates: []tls.Certificate{certificate}}).Handshake()
+		_ = sc.Close()
+	}()
+	if err = tls.Client(cc, config).Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if opt.TLSConfig.ServerName != "" {
+		t.Fatal("TLSConfig of option modified")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx"
+	"time"
+)
+
+const (
+	RPC_SERVER_URL = "tcp://127.0.0.1:6670?framer=len4"
+)
+
+type AddRequest struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func init() {
+	log.SetLevel("debug")
+}
+
+func main() {
+	c := socketx.NewClient()
+	if err := c.Connect(RPC_SERVER_URL); err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	c.HandleRPC("version", func(c *socketx.SocketClient, req *socketx.RPCRequest) (interface{}, error) {
+		return "v1.0.0", nil
+	})
+	for i := 0; ; i++ {
+		var sum int
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		err := c.Call(ctx, "add", &AddRequest{A: i, B: 1}, &sum)
+		cancel()
+		if err != nil {
+			log.Errorf(err.Error())
+			break
+		}
+		log.Infof("add [%v + 1] = [%v]", i, sum)
+		time.Sleep(3 * time.Second)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx"
+	"github.com/civet148/socketx/api"
+	"time"
+)
+
+const (
+	RPC_SERVER_URL = "tcp://0.0.0.0:6670?framer=len4"
+)
+
+type AddRequest struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type ServerHandler struct {
+}
+
+func init() {
+	log.SetLevel("debug")
+}
+
+func main() {
+	var handler ServerHandler
+	sock := socketx.NewServer(RPC_SERVER_URL)
+	sock.HandleRPC("add", func(c *socketx.SocketClient, req *socketx.RPCRequest) (interface{}, error) {
+		var r AddRequest
+		if err := req.Bind(&r); err != nil {
+			return nil, err
+		}
+		return r.A + r.B, nil
+	})
+	if err := sock.Listen(&handler); err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+}
+
+func (s *ServerHandler) OnAccept(c *socketx.SocketClient) {
+	log.Infof("connection accepted [%v]", c.GetRemoteAddr())
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		var version string
+		if err := c.Call(ctx, "version", nil, &version); err != nil { //call client
+			log.Errorf(err.Error())
+			return
+		}
+		log.Infof("client [%v] version [%s]", c.GetRemoteAddr(), version)
+	}()
+}
+
+func (s *ServerHandler) OnReceive(c *socketx.SocketClient, msg *api.SockMessage) {
+	log.Infof("server received data [%s] length [%v]", msg.Data, len(msg.Data))
+}
+
+func (s *ServerHandler) OnClose(c *socketx.SocketClient) {
+	log.Infof("connection [%v] closed", c.GetRemoteAddr())
+}
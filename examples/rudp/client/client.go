@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/civet148/log"
+	"github.com/civet148/socketx"
+	"time"
+)
+
+const (
+	RUDP_DATA_PING  = "ping"
+	RUDP_DATA_PONG  = "pong"
+	RUDP_DATA_STATE = "state"
+)
+
+func init() {
+	log.SetLevel("debug")
+}
+
+func main() {
+	var strUrl = "rudp://127.0.0.1:6669"
+	c := socketx.NewClient()
+	if err := c.Connect(strUrl); err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	defer c.Close()
+
+	for {
+		if _, err := c.SendUnreliable([]byte(RUDP_DATA_STATE)); err != nil { //may be lost, no retransmission
+			log.Errorf(err.Error())
+			break
+		}
+		if _, err := c.Send([]byte(RUDP_DATA_PING)); err != nil { //reliable and ordered
+			log.Errorf(err.Error())
+			break
+		}
+
+		msg, err := c.Recv(len(RUDP_DATA_PONG))
+		if err != nil {
+			log.Error(err.Error())
+			break
+		}
+		data := msg.Data
+		from := msg.From
+		log.Infof("client received data [%s] length [%v] from [%v]", string(msg.Data), len(data), from)
+		time.Sleep(1 * time.Second)
+	}
+}
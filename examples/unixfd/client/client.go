@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/civet148/log"
+	"github.com/civet148/socketx"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	UNIX_SOCKET_URL = "unix:///tmp/unixfd.sock?framer=len4"
+)
+
+func init() {
+	log.SetLevel("debug")
+}
+
+func main() {
+	c := socketx.NewClient()
+	if err := c.Connect(UNIX_SOCKET_URL); err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	defer c.Close()
+	cred, err := c.GetPeerCredential()
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	log.Infof("server pid [%v] uid [%v] gid [%v]", cred.PID, cred.UID, cred.GID)
+
+	f, err := ioutil.TempFile("", "unixfd")
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	defer os.Remove(f.Name())
+	_, _ = f.WriteString("hello from client")
+	_, _ = f.Seek(0, 0)
+	if _, err = c.SendFiles([]byte("file"), f); err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	_ = f.Close() //the server holds a duplicated file
+	msg, err := c.Recv(-1)
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	log.Infof("client received data [%s]", msg.Data)
+}
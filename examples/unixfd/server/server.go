@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/civet148/log"
+	"github.com/civet148/socketx"
+	"github.com/civet148/socketx/api"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	UNIX_SOCKET_URL = "unix:///tmp/unixfd.sock?framer=len4"
+)
+
+type ServerHandler struct {
+}
+
+func init() {
+	log.SetLevel("debug")
+}
+
+func main() {
+	var handler ServerHandler
+	sock := socketx.NewServer(UNIX_SOCKET_URL)
+	if err := sock.Listen(&handler); err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+}
+
+func (s *ServerHandler) OnAccept(c *socketx.SocketClient) {
+	cred, err := c.GetPeerCredential()
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	log.Infof("connection accepted pid [%v] uid [%v] gid [%v]", cred.PID, cred.UID, cred.GID)
+	if int(cred.UID) != os.Getuid() {
+		log.Warnf("uid [%v] not authorized", cred.UID)
+		_ = c.Close()
+	}
+}
+
+func (s *ServerHandler) OnReceive(c *socketx.SocketClient, msg *api.SockMessage) {
+	log.Infof("server received data [%s] with [%v] files", msg.Data, len(msg.Files))
+	for _, f := range msg.Files {
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			log.Errorf(err.Error())
+		}
+		log.Infof("file content [%s]", data)
+		_ = f.Close()
+	}
+	if _, err := c.Send([]byte("ok")); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+func (s *ServerHandler) OnClose(c *socketx.SocketClient) {
+	log.Infof("connection [%v] closed", c.GetRemoteAddr())
+}
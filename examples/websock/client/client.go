@@ -8,7 +8,8 @@ import (
 )
 
 const (
-	WEBSOCKET_SERVER_URL = "wss://127.0.0.1:6668/websocket"
+	WEBSOCKET_SERVER_URL  = "wss://127.0.0.1:6668/websocket"
+	WEBSOCKET_SERVER_CERT = "56da96b05b944c52a89d0f880793e48fe81546c02104a8f594e86be684be8da2" //SHA-256 of server cert.pem
 )
 
 const (
@@ -23,7 +24,11 @@ func init() {
 func main() {
 	var err error
 	c := socketx.NewClient()
-	if err = c.Connect(WEBSOCKET_SERVER_URL); err != nil {
+	//the example certificate is self-signed without subject alternative names, pin it instead of CA verification
+	if err = c.Connect(WEBSOCKET_SERVER_URL, api.SocketOption{
+		Insecure:    true,
+		PinnedCerts: []string{WEBSOCKET_SERVER_CERT},
+	}); err != nil {
 		log.Errorf(err.Error())
 		return
 	}
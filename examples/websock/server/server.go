@@ -36,7 +36,7 @@ func (s *ServerHandler) OnReceive(c *socketx.SocketClient, msg *api.SockMessage)
 	length := len(data)
 	log.Infof("server received data [%s] length [%v] from [%v] type [%v]", data, length, from, msg.MsgType)
 	if string(data) == WEBSOCKET_DATA_PING {
-		if _, err := c.Send([]byte(WEBSOCKET_DATA_PONG)); err != nil {
+		if _, err := c.SendMessage(msg.MsgType, []byte(WEBSOCKET_DATA_PONG)); err != nil { //reply with the same message type
 			log.Errorf(err.Error())
 		}
 	}
@@ -7,4 +7,5 @@ require (
 	github.com/civet148/log v1.4.4
 	github.com/gin-gonic/gin v1.9.1
 	github.com/gorilla/websocket v1.5.0
+	golang.org/x/net v0.10.0
 )
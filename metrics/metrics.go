@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/civet148/socketx/api"
+	"github.com/civet148/socketx/types"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	namespace   = "socketx"
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var _ api.Metrics = (*Registry)(nil)
+
+// DefaultBuckets default buckets (seconds) of handler latency histogram
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Registry is the built-in api.Metrics implementation, it is also a http.Handler which exports the metrics in
+// Prometheus text format, eg. http.Handle("/metrics", registry)
+type Registry struct {
+	locker  sync.RWMutex
+	series  map[types.SocketType]*series
+	buckets []float64
+}
+
+// series metrics of a socket type, the int64 fields are accessed atomically so keep them first for alignment
+type series struct {
+	accepted int64
+	closed   int64
+	rejected int64
+	bytesIn  int64
+	msgsIn   int64
+	bytesOut int64
+	msgsOut  int64
+	recvErrs int64
+	sendErrs int64
+	locker   sync.Mutex
+	counts   []uint64 //handler latency count of each bucket (not cumulative)
+	count    uint64   //handler latency count
+	sum      float64  //handler latency sum (seconds)
+}
+
+// NewRegistry create a metrics registry, buckets (seconds, ascending) of handler latency histogram default DefaultBuckets
+func NewRegistry(buckets ...float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Registry{
+		series:  make(map[types.SocketType]*series),
+		buckets: buckets,
+	}
+}
+
+func (r *Registry) ConnAccepted(st types.SocketType) {
+	atomic.AddInt64(&r.get(st).accepted, 1)
+}
+
+func (r *Registry) ConnClosed(st types.SocketType) {
+	atomic.AddInt64(&r.get(st).closed, 1)
+}
+
+func (r *Registry) ConnRejected(st types.SocketType) {
+	atomic.AddInt64(&r.get(st).rejected, 1)
+}
+
+func (r *Registry) MessageIn(st types.SocketType, bytes int) {
+	s := r.get(st)
+	atomic.AddInt64(&s.msgsIn, 1)
+	atomic.AddInt64(&s.bytesIn, int64(bytes))
+}
+
+func (r *Registry) MessageOut(st types.SocketType, bytes int) {
+	s := r.get(st)
+	atomic.AddInt64(&s.msgsOut, 1)
+	atomic.AddInt64(&s.bytesOut, int64(bytes))
+}
+
+func (r *Registry) RecvError(st types.SocketType) {
+	atomic.AddInt64(&r.get(st).recvErrs, 1)
+}
+
+func (r *Registry) SendError(st types.SocketType) {
+	atomic.AddInt64(&r.get(st).sendErrs, 1)
+}
+
+func (r *Registry) HandlerLatency(st types.SocketType, d time.Duration) {
+	s := r.get(st)
+	v := d.Seconds()
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for i, le := range r.buckets {
+		if v <= le {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// ServeHTTP write metrics in Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	if err := r.Write(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Write write metrics in Prometheus text format to w
+func (r *Registry) Write(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	all := r.getAll()
+	counter := func(name, help string, value func(s *series) int64) {
+		writeHeader(bw, name, help, "counter")
+		for _, st := range all.sockTypes {
+			fmt.Fprintf(bw, "%s{type=%q} %d\n", name, st.String(), value(all.series[st]))
+		}
+	}
+	counter(namespace+"_connections_accepted_total", "Connections accepted by server or established by client.", func(s *series) int64 {
+		return atomic.LoadInt64(&s.accepted)
+	})
+	counter(namespace+"_connections_closed_total", "Connections closed.", func(s *series) int64 {
+		return atomic.LoadInt64(&s.closed)
+	})
+	counter(namespace+"_connections_rejected_total", "Connections rejected by server admission control.", func(s *series) int64 {
+		return atomic.LoadInt64(&s.rejected)
+	})
+	writeHeader(bw, namespace+"_connections_active", "Connections active.", "gauge")
+	for _, st := range all.sockTypes {
+		s := all.series[st]
+		fmt.Fprintf(bw, "%s_connections_active{type=%q} %d\n", namespace, st.String(), atomic.LoadInt64(&s.accepted)-atomic.LoadInt64(&s.closed))
+	}
+	counter(namespace+"_received_bytes_total", "Bytes received.", func(s *series) int64 {
+		return atomic.LoadInt64(&s.bytesIn)
+	})
+	counter(namespace+"_received_messages_total", "Messages received.", func(s *series) int64 {
+		return atomic.LoadInt64(&s.msgsIn)
+	})
+	counter(namespace+"_sent_bytes_total", "Bytes sent.", func(s *series) int64 {
+		return atomic.LoadInt64(&s.bytesOut)
+	})
+	counter(namespace+"_sent_messages_total", "Messages sent.", func(s *series) int64 {
+		return atomic.LoadInt64(&s.msgsOut)
+	})
+	counter(namespace+"_recv_errors_total", "Messages dropped or read errors.", func(s *series) int64 {
+		return atomic.LoadInt64(&s.recvErrs)
+	})
+	counter(namespace+"_send_errors_total", "Send errors.", func(s *series) int64 {
+		return atomic.LoadInt64(&s.sendErrs)
+	})
+	name := namespace + "_handler_duration_seconds"
+	writeHeader(bw, name, "OnReceive handler latency.", "histogram")
+	for _, st := range all.sockTypes {
+		s := all.series[st]
+		s.locker.Lock()
+		var cumulative uint64
+		for i, le := range r.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(bw, "%s_bucket{type=%q,le=%q} %d\n", name, st.String(), formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(bw, "%s_bucket{type=%q,le=\"+Inf\"} %d\n", name, st.String(), s.count)
+		fmt.Fprintf(bw, "%s_sum{type=%q} %s\n", name, st.String(), formatFloat(s.sum))
+		fmt.Fprintf(bw, "%s_count{type=%q} %d\n", name, st.String(), s.count)
+		s.locker.Unlock()
+	}
+	return bw.Flush()
+}
+
+// get the series of socket type, created if not exist
+func (r *Registry) get(st types.SocketType) *series {
+	r.locker.RLock()
+	s, ok := r.series[st]
+	r.locker.RUnlock()
+	if ok {
+		return s
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if s, ok = r.series[st]; !ok {
+		s = &series{counts: make([]uint64, len(r.buckets))}
+		r.series[st] = s
+	}
+	return s
+}
+
+type snapshot struct {
+	sockTypes []types.SocketType
+	series    map[types.SocketType]*series
+}
+
+// getAll returns the series sorted by socket type
+func (r *Registry) getAll() (all snapshot) {
+	r.locker.RLock()
+	defer r.locker.RUnlock()
+	all.series = make(map[types.SocketType]*series, len(r.series))
+	for st, s := range r.series {
+		all.sockTypes = append(all.sockTypes, st)
+		all.series[st] = s
+	}
+	sort.Slice(all.sockTypes, func(i, j int) bool {
+		return all.sockTypes[i] < all.sockTypes[j]
+	})
+	return
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
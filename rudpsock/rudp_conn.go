@@ -0,0 +1,498 @@
+package rudpsock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/civet148/log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	sendWindow     = 256                    // max reliable messages in flight
+	recvQueue      = 1024                   // max messages queued for Recv
+	payloadMax     = 1200                   // max message size, keep a packet in one IP datagram
+	rtoInit        = 500 * time.Millisecond // initial retransmission timeout
+	rtoMin         = 50 * time.Millisecond  // min retransmission timeout
+	rtoMax         = 5 * time.Second        // max retransmission timeout
+	maxRetries     = 15                     // connection lost after a message retransmitted N times
+	tickInterval   = 10 * time.Millisecond  // retransmission timer resolution
+	lingerTimeout  = time.Second            // max time to wait for in flight messages acknowledged when closing
+	connectTimeout = 10 * time.Second       // connect timeout if context has no deadline
+)
+
+var (
+	ErrConnClosed     = errors.New("rudp: connection closed")
+	ErrPeerClosed     = errors.New("rudp: connection closed by peer")
+	ErrPeerReset      = errors.New("rudp: connection reset by a new connection of peer address")
+	ErrPeerTimeout    = errors.New("rudp: message retransmission timeout")
+	ErrPayloadTooLong = fmt.Errorf("rudp: message longer than %d bytes", payloadMax)
+)
+
+type pending struct {
+	data     []byte    //encoded packet
+	sentAt   time.Time //first sent time
+	deadline time.Time //retransmission time
+	retries  int       //retransmission count
+	fast     bool      //fast retransmitted
+}
+
+// conn reliable connection with a remote address, reliable messages are retransmitted until acknowledged and
+// delivered in order, unreliable messages are delivered as soon as received
+type conn struct {
+	pc          net.PacketConn
+	remote      net.Addr
+	locker      sync.Mutex
+	isn         uint32              //random initial sequence number of reliable messages sent, tells the connections of an address
+	peerISN     uint32              //initial sequence number of peer, set when connection established
+	nextSeq     uint32              //next sequence number of reliable message to send
+	base        uint32              //next sequence number expected by peer, send window is [base, base+sendWindow)
+	pending     map[uint32]*pending //reliable messages not acknowledged
+	expected    uint32              //next sequence number of reliable message to deliver
+	buffered    map[uint32][]byte   //reliable messages received out of order
+	inbox       chan []byte         //messages to Recv
+	wake        chan struct{}       //send window available
+	srtt        time.Duration       //smoothed round trip time
+	rttvar      time.Duration       //round trip time variation
+	rto         time.Duration       //retransmission timeout
+	quit        chan struct{}       //closed when connection closed
+	established chan struct{}       //closed when connection established
+	closing     bool                //no more message sent, closed after in flight messages acknowledged
+	once        sync.Once           //close once
+	onceSyn     sync.Once           //establish once
+	err         error               //close reason
+	onClose     func(c *conn)       //called when connection closed
+	pongAt      time.Time           //last pong received time
+	rtt         time.Duration       //last ping round trip time
+}
+
+func newConn(pc net.PacketConn, remote net.Addr, onClose func(c *conn)) *conn {
+	isn := randomISN()
+	c := &conn{
+		pc:          pc,
+		remote:      remote,
+		isn:         isn,
+		nextSeq:     isn,
+		base:        isn,
+		pending:     make(map[uint32]*pending),
+		buffered:    make(map[uint32][]byte),
+		inbox:       make(chan []byte, recvQueue),
+		wake:        make(chan struct{}, 1),
+		rto:         rtoInit,
+		quit:        make(chan struct{}),
+		established: make(chan struct{}),
+		onClose:     onClose,
+	}
+	go c.run()
+	return c
+}
+
+// connect send SYN until SYN-ACK received
+func (c *conn) connect(ctx context.Context) (err error) {
+	interval := rtoInit / 2
+	for {
+		if err = c.write(encodeSyn(c.isn)); err != nil {
+			return err
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-c.established:
+			timer.Stop()
+			return nil
+		case <-c.quit:
+			timer.Stop()
+			return c.getError()
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if interval *= 2; interval > rtoMax {
+			interval = rtoMax
+		}
+	}
+}
+
+// establish the connection with the ISN of peer, the reliable messages of peer are expected from it
+func (c *conn) establish(peerISN uint32) {
+	c.onceSyn.Do(func() {
+		c.locker.Lock()
+		c.peerISN, c.expected = peerISN, peerISN
+		c.locker.Unlock()
+		close(c.established)
+	})
+}
+
+func (c *conn) isEstablished() bool {
+	select {
+	case <-c.established:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *conn) getPeerISN() uint32 {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.peerISN
+}
+
+// send a message, wait for send window available if the message is reliable
+func (c *conn) send(ctx context.Context, data []byte, reliable bool) (n int, err error) {
+	if len(data) > payloadMax {
+		return 0, ErrPayloadTooLong
+	}
+	if !reliable {
+		if err = c.write(encodeData(0, false, data)); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	for {
+		c.locker.Lock()
+		if c.closing {
+			c.locker.Unlock()
+			return 0, ErrConnClosed
+		}
+		select {
+		case <-c.quit:
+			c.locker.Unlock()
+			return 0, c.getError()
+		default:
+		}
+		if c.nextSeq-c.base < sendWindow {
+			seq := c.nextSeq
+			c.nextSeq++
+			now := time.Now()
+			p := &pending{
+				data:     encodeData(seq, true, data),
+				sentAt:   now,
+				deadline: now.Add(c.rto),
+			}
+			c.pending[seq] = p
+			c.locker.Unlock()
+			if err = c.write(p.data); err != nil {
+				return 0, err
+			}
+			return len(data), nil
+		}
+		c.locker.Unlock()
+		select {
+		case <-c.wake:
+		case <-c.quit:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// recv a message in order of reliable messages
+func (c *conn) recv(ctx context.Context) (data []byte, err error) {
+	select {
+	case data = <-c.inbox:
+		return data, nil
+	default:
+	}
+	select {
+	case data = <-c.inbox:
+		return data, nil
+	case <-c.quit:
+		return nil, c.getError()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// input handle a packet received from remote address, the data and acknowledgements before established are dropped
+// (retransmitted by peer) since the ISN of peer is unknown
+func (c *conn) input(p *packet) {
+	switch p.typ {
+	case packetData:
+		if p.reliable() {
+			if !c.isEstablished() {
+				return
+			}
+			c.onReliable(p)
+		} else {
+			c.deliver(p.payload)
+		}
+	case packetAck:
+		if c.isEstablished() {
+			c.onAck(p)
+		}
+	case packetSyn:
+		if peerISN := c.getPeerISN(); p.seq == peerISN { //SYN-ACK lost, SYN retransmitted
+			_ = c.write(encodeSynAck(c.isn, peerISN))
+		}
+	case packetSynAck:
+		if p.echo == c.isn { //not the SYN-ACK of a former connection
+			c.establish(p.seq)
+		}
+	case packetFin:
+		c.close(ErrPeerClosed, false)
+	case packetPing:
+		_ = c.write(encodePing(packetPong, p.nano))
+	case packetPong:
+		now := time.Now()
+		c.locker.Lock()
+		c.pongAt = now
+		c.rtt = now.Sub(time.Unix(0, p.nano))
+		c.locker.Unlock()
+	}
+}
+
+// onReliable deliver the message in order, buffer it if received out of order and acknowledge
+func (c *conn) onReliable(p *packet) {
+	c.locker.Lock()
+	switch {
+	case seqBefore(p.seq, c.expected): //duplicated
+	case p.seq == c.expected:
+		if c.deliver(p.payload) {
+			c.expected++
+			for {
+				data, ok := c.buffered[c.expected]
+				if !ok || !c.deliver(data) {
+					break
+				}
+				delete(c.buffered, c.expected)
+				c.expected++
+			}
+		}
+	case p.seq-c.expected < sendWindow:
+		if _, ok := c.buffered[p.seq]; !ok {
+			c.buffered[p.seq] = p.payload
+		}
+	}
+	bitmap := make([]byte, sackBitmapLen/8)
+	for seq := range c.buffered {
+		if off := seq - c.expected - 1; seq != c.expected && off < sackBitmapLen {
+			bitmap[off/8] |= 1 << (off % 8)
+		}
+	}
+	ack := encodeAck(c.expected, bitmap)
+	c.locker.Unlock()
+	_ = c.write(ack)
+}
+
+// onAck remove the acknowledged messages and update retransmission timeout
+func (c *conn) onAck(p *packet) {
+	now := time.Now()
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	var removed, selected bool
+	if seqBefore(c.nextSeq, p.seq) { //acknowledge messages never sent, eg. from a former connection
+		return
+	}
+	if seqBefore(c.base, p.seq) {
+		c.base = p.seq
+		removed = true
+	}
+	var highest uint32 //highest sequence number selectively acknowledged
+	for seq, pend := range c.pending {
+		if !seqBefore(seq, p.seq) && !p.selected(seq) {
+			continue
+		}
+		if p.selected(seq) && (!selected || seqBefore(highest, seq)) {
+			highest, selected = seq, true
+		}
+		if pend.retries == 0 { //Karn's algorithm, only sample messages not retransmitted
+			c.updateRTO(now.Sub(pend.sentAt))
+		}
+		delete(c.pending, seq)
+		removed = true
+	}
+	if selected { //fast retransmit the messages before the highest one acknowledged
+		for seq, pend := range c.pending {
+			if seqBefore(seq, highest) && !pend.fast && pend.retries == 0 {
+				pend.fast = true
+				pend.deadline = now
+			}
+		}
+	}
+	if removed {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// updateRTO estimate retransmission timeout by RFC 6298
+func (c *conn) updateRTO(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = rtt
+		c.rttvar = rtt / 2
+	} else {
+		delta := c.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	variance := 4 * c.rttvar
+	if variance < tickInterval {
+		variance = tickInterval
+	}
+	c.rto = c.srtt + variance
+	if c.rto < rtoMin {
+		c.rto = rtoMin
+	} else if c.rto > rtoMax {
+		c.rto = rtoMax
+	}
+}
+
+// deliver queue the message for Recv, false returned if the queue is full
+func (c *conn) deliver(data []byte) bool {
+	select {
+	case c.inbox <- data:
+		return true
+	default:
+		log.Warnf("rudp connection [%s] receive queue is full, message dropped", c.remote)
+		return false
+	}
+}
+
+// run retransmit the messages not acknowledged before timeout
+func (c *conn) run() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+		}
+		var resends [][]byte
+		var dead bool
+		now := time.Now()
+		c.locker.Lock()
+		for _, pend := range c.pending {
+			if now.Before(pend.deadline) {
+				continue
+			}
+			if pend.retries >= maxRetries {
+				dead = true
+				break
+			}
+			pend.retries++
+			pend.deadline = now.Add(c.backoff(pend.retries))
+			resends = append(resends, pend.data)
+		}
+		c.locker.Unlock()
+		if dead {
+			c.close(ErrPeerTimeout, true)
+			return
+		}
+		for _, data := range resends {
+			_ = c.write(data)
+		}
+	}
+}
+
+// shutdown stop sending reliable messages
+func (c *conn) shutdown() {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.closing = true
+}
+
+// backoff returns retransmission timeout of the Nth retransmission, back off by 1.5 instead of 2 since
+// the loss of game traffic is not always congestion
+func (c *conn) backoff(retries int) time.Duration {
+	timeout := c.rto
+	for i := 0; i < retries && timeout < rtoMax; i++ {
+		timeout = timeout * 3 / 2
+	}
+	if timeout > rtoMax {
+		timeout = rtoMax
+	}
+	return timeout
+}
+
+// linger wait for in flight reliable messages acknowledged until timeout and then close connection
+func (c *conn) linger(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	defer c.close(ErrConnClosed, true)
+	for {
+		c.locker.Lock()
+		n := len(c.pending)
+		c.locker.Unlock()
+		if n == 0 {
+			return
+		}
+		select {
+		case <-c.wake:
+		case <-c.quit:
+			return
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// close connection, notify peer by FIN if notify is true
+func (c *conn) close(err error, notify bool) {
+	c.once.Do(func() {
+		c.locker.Lock()
+		c.err = err
+		c.locker.Unlock()
+		close(c.quit)
+		if notify {
+			_ = c.write(encodeControl(packetFin))
+		}
+		if c.onClose != nil {
+			c.onClose(c)
+		}
+	})
+}
+
+func (c *conn) isClosed() bool {
+	select {
+	case <-c.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *conn) getError() error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if c.err == nil {
+		return ErrConnClosed
+	}
+	return c.err
+}
+
+func (c *conn) ping() error {
+	return c.write(encodePing(packetPing, time.Now().UnixNano()))
+}
+
+func (c *conn) getPong() (last time.Time, rtt time.Duration) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.pongAt, c.rtt
+}
+
+// randomISN returns a random initial sequence number
+func randomISN() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint32(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func (c *conn) write(data []byte) (err error) {
+	if _, err = c.pc.WriteTo(data, c.remote); err != nil {
+		return log.Errorf("write to [%s] error [%s]", c.remote, err.Error())
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package rudpsock
+
+import (
+	"github.com/civet148/gotools/parser"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/types"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// lossyConn is a test harness which drops and reorders outgoing packets, enabled by url queries 'loss' and 'reorder',
+// eg. rudp://127.0.0.1:9999?loss=0.2&reorder=0.1&delay=30ms
+type lossyConn struct {
+	net.PacketConn
+	loss    float64       //drop probability
+	reorder float64       //delay probability
+	delay   time.Duration //delay of reordered packets
+	locker  sync.Mutex
+	rnd     *rand.Rand
+}
+
+// newLossyConn wrap pc with loss and reorder injection if url queries set, otherwise pc returned
+func newLossyConn(ui *parser.UrlInfo, pc net.PacketConn) (net.PacketConn, error) {
+	strLoss := ui.Queries[types.URL_QUERY_LOSS]
+	strReorder := ui.Queries[types.URL_QUERY_REORDER]
+	if strLoss == "" && strReorder == "" {
+		return pc, nil
+	}
+	var err error
+	lc := &lossyConn{
+		PacketConn: pc,
+		delay:      20 * time.Millisecond,
+		rnd:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if lc.loss, err = parseProbability(strLoss); err != nil {
+		_ = pc.Close()
+		return nil, log.Errorf("invalid loss [%s]", strLoss)
+	}
+	if lc.reorder, err = parseProbability(strReorder); err != nil {
+		_ = pc.Close()
+		return nil, log.Errorf("invalid reorder [%s]", strReorder)
+	}
+	if strDelay := ui.Queries[types.URL_QUERY_DELAY]; strDelay != "" {
+		if lc.delay, err = time.ParseDuration(strDelay); err != nil {
+			_ = pc.Close()
+			return nil, log.Errorf("invalid delay [%s]", strDelay)
+		}
+	}
+	log.Warnf("RUDP test harness enabled, loss [%v] reorder [%v] delay [%v]", lc.loss, lc.reorder, lc.delay)
+	return lc, nil
+}
+
+func (lc *lossyConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	lc.locker.Lock()
+	drop := lc.rnd.Float64() < lc.loss
+	delay := lc.rnd.Float64() < lc.reorder
+	lc.locker.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	if delay {
+		data := copyBytes(b)
+		time.AfterFunc(lc.delay, func() {
+			_, _ = lc.PacketConn.WriteTo(data, addr)
+		})
+		return len(b), nil
+	}
+	return lc.PacketConn.WriteTo(b, addr)
+}
+
+func parseProbability(s string) (p float64, err error) {
+	if s == "" {
+		return 0, nil
+	}
+	if p, err = strconv.ParseFloat(s, 64); err != nil || p < 0 || p > 1 {
+		return 0, strconv.ErrRange
+	}
+	return
+}
@@ -0,0 +1,146 @@
+package rudpsock
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	packetData    = 1 // data message, reliable or unreliable
+	packetAck     = 2 // cumulative and selective acknowledgement
+	packetSyn     = 3 // connect request
+	packetSynAck  = 4 // connect response
+	packetFin     = 5 // connection closed
+	packetPing    = 6 // heartbeat ping with timestamp
+	packetPong    = 7 // heartbeat pong echo timestamp
+	flagReliable  = 1 // data message is reliable and ordered
+	headerSize    = 2 // packet type + flags
+	dataHeader    = headerSize + 4
+	sackBitmapLen = 256 // selective acknowledgement bits, cover the whole send window
+	ackHeader     = headerSize + 4 + sackBitmapLen/8
+	pingHeader    = headerSize + 8
+	synHeader     = headerSize + 4
+	synAckHeader  = headerSize + 8
+)
+
+// packet layout
+//
+//	DATA      type(1) flags(1) seq(4) payload
+//	ACK       type(1) 0(1) next expected seq(4) bitmap of received seq after next(32)
+//	PING/PONG type(1) 0(1) unix nano(8)
+//	SYN       type(1) 0(1) ISN(4)
+//	SYN-ACK   type(1) 0(1) ISN(4) ISN of peer(4)
+//	FIN       type(1) 0(1)
+type packet struct {
+	typ     byte
+	flags   byte
+	seq     uint32 //DATA: sequence number of reliable message, ACK: next expected sequence number, SYN/SYN-ACK: ISN
+	echo    uint32 //SYN-ACK: ISN of SYN echoed
+	bitmap  []byte //ACK: bit i set if seq+1+i received
+	nano    int64  //PING/PONG: timestamp
+	payload []byte //DATA: message
+}
+
+func (p *packet) reliable() bool {
+	return p.flags&flagReliable != 0
+}
+
+func encodeData(seq uint32, reliable bool, payload []byte) []byte {
+	b := make([]byte, dataHeader+len(payload))
+	b[0] = packetData
+	if reliable {
+		b[1] = flagReliable
+	}
+	binary.BigEndian.PutUint32(b[2:], seq)
+	copy(b[dataHeader:], payload)
+	return b
+}
+
+func encodeAck(next uint32, bitmap []byte) []byte {
+	b := make([]byte, ackHeader)
+	b[0] = packetAck
+	binary.BigEndian.PutUint32(b[2:], next)
+	copy(b[6:], bitmap)
+	return b
+}
+
+func encodePing(typ byte, nano int64) []byte {
+	b := make([]byte, pingHeader)
+	b[0] = typ
+	binary.BigEndian.PutUint64(b[2:], uint64(nano))
+	return b
+}
+
+func encodeSyn(isn uint32) []byte {
+	b := make([]byte, synHeader)
+	b[0] = packetSyn
+	binary.BigEndian.PutUint32(b[2:], isn)
+	return b
+}
+
+func encodeSynAck(isn, echo uint32) []byte {
+	b := make([]byte, synAckHeader)
+	b[0] = packetSynAck
+	binary.BigEndian.PutUint32(b[2:], isn)
+	binary.BigEndian.PutUint32(b[6:], echo)
+	return b
+}
+
+func encodeControl(typ byte) []byte {
+	return []byte{typ, 0}
+}
+
+func decodePacket(b []byte) (p *packet, err error) {
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("packet too short [%d]", len(b))
+	}
+	p = &packet{typ: b[0], flags: b[1]}
+	switch p.typ {
+	case packetData:
+		if len(b) < dataHeader {
+			return nil, fmt.Errorf("data packet too short [%d]", len(b))
+		}
+		p.seq = binary.BigEndian.Uint32(b[2:])
+		p.payload = b[dataHeader:]
+	case packetAck:
+		if len(b) < ackHeader {
+			return nil, fmt.Errorf("ack packet too short [%d]", len(b))
+		}
+		p.seq = binary.BigEndian.Uint32(b[2:])
+		p.bitmap = b[6:ackHeader]
+	case packetPing, packetPong:
+		if len(b) < pingHeader {
+			return nil, fmt.Errorf("ping packet too short [%d]", len(b))
+		}
+		p.nano = int64(binary.BigEndian.Uint64(b[2:]))
+	case packetSyn:
+		if len(b) < synHeader {
+			return nil, fmt.Errorf("syn packet too short [%d]", len(b))
+		}
+		p.seq = binary.BigEndian.Uint32(b[2:])
+	case packetSynAck:
+		if len(b) < synAckHeader {
+			return nil, fmt.Errorf("syn-ack packet too short [%d]", len(b))
+		}
+		p.seq = binary.BigEndian.Uint32(b[2:])
+		p.echo = binary.BigEndian.Uint32(b[6:])
+	case packetFin:
+	default:
+		return nil, fmt.Errorf("unknown packet type [%d]", p.typ)
+	}
+	return
+}
+
+// selected returns true if seq is selectively acknowledged
+func (p *packet) selected(seq uint32) bool {
+	off := seq - p.seq - 1
+	if seq == p.seq || off >= sackBitmapLen {
+		return false
+	}
+	return p.bitmap[off/8]&(1<<(off%8)) != 0
+}
+
+// seqBefore returns true if sequence number a is before b (wrap around safe)
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
@@ -0,0 +1,348 @@
+package rudpsock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/civet148/gotools/parser"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"github.com/civet148/socketx/types"
+	"net"
+	"sync"
+	"time"
+)
+
+const packetMax = 64 * 1024 // read buffer size
+
+type socket struct {
+	ui        *parser.UrlInfo
+	pc        net.PacketConn   //packet connection of listening socket or client socket
+	conn      *conn            //reliable connection, nil for listening socket
+	accepted  bool             //connection accepted by listening socket
+	closed    bool             //socket closed
+	locker    sync.Mutex       //connections locker
+	conns     map[string]*conn //connections of listening socket by remote address
+	accepting chan *conn       //new connections to accept
+	quit      chan struct{}    //closed when listening socket closed
+}
+
+func init() {
+	_ = api.Register(types.SocketType_RUDP, NewSocket)
+}
+
+func NewSocket(ui *parser.UrlInfo, options ...api.SocketOption) api.Socket {
+	return &socket{
+		ui: ui,
+	}
+}
+
+func (s *socket) Listen() (err error) {
+	var strAddr = s.ui.GetHost()
+	if s.pc, err = s.listenPacket(strAddr); err != nil {
+		return log.Errorf("listen RUDP addr [%v] error [%v]", strAddr, err.Error())
+	}
+	s.conns = make(map[string]*conn)
+	s.accepting = make(chan *conn, 1000)
+	s.quit = make(chan struct{})
+	go s.dispatch()
+	return
+}
+
+// Accept returns a new connection after SYN received, nil returned when socket closed
+func (s *socket) Accept() api.Socket {
+	select {
+	case <-s.quit:
+		return nil
+	case c := <-s.accepting:
+		return &socket{
+			ui:       s.ui,
+			pc:       s.pc,
+			conn:     c,
+			accepted: true,
+		}
+	}
+}
+
+func (s *socket) Connect() (err error) {
+	return s.ConnectContext(context.Background())
+}
+
+// ConnectContext connect to server by SYN/SYN-ACK handshake, the default connect timeout is 10s if ctx has no deadline
+func (s *socket) ConnectContext(ctx context.Context) (err error) {
+	var strAddr = s.ui.GetHost()
+	var udpAddr *net.UDPAddr
+	if udpAddr, err = net.ResolveUDPAddr(s.getNetwork(), strAddr); err != nil {
+		return log.Errorf("resolve UDP addr [%v] error [%v]", strAddr, err.Error())
+	}
+	if s.pc, err = s.listenPacket(""); err != nil {
+		return log.Errorf("listen RUDP client error [%v]", err.Error())
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, connectTimeout)
+		defer cancel()
+	}
+	s.conn = newConn(s.pc, udpAddr, func(c *conn) {
+		_ = s.pc.Close()
+	})
+	go s.read()
+	if err = s.conn.connect(ctx); err != nil {
+		s.conn.close(err, false)
+		if ctx.Err() != nil {
+			log.Errorf("connect RUDP [%s] error [%s]", strAddr, err.Error())
+			return api.NewTimeoutError("connect", ctx.Err())
+		}
+		return log.Errorf("connect RUDP [%s] error [%s]", strAddr, err.Error())
+	}
+	return
+}
+
+// Send send a reliable and ordered message, the to parameter is ignored
+func (s *socket) Send(data []byte, to ...string) (n int, err error) {
+	return s.SendContext(context.Background(), data, to...)
+}
+
+func (s *socket) SendContext(ctx context.Context, data []byte, to ...string) (n int, err error) {
+	if s.conn == nil {
+		return 0, fmt.Errorf("socket is nil")
+	}
+	if n, err = s.conn.send(ctx, data, true); err != nil && ctx.Err() != nil {
+		return 0, api.NewTimeoutError("send", ctx.Err())
+	}
+	return
+}
+
+// SendUnreliable send a message without retransmission and ordering
+func (s *socket) SendUnreliable(data []byte) (n int, err error) {
+	if s.conn == nil {
+		return 0, fmt.Errorf("socket is nil")
+	}
+	return s.conn.send(context.Background(), data, false)
+}
+
+func (s *socket) SendJson(v interface{}, to ...string) (n int, err error) {
+	var data []byte
+	data, err = json.Marshal(v)
+	if err != nil {
+		return 0, log.Errorf(err.Error())
+	}
+	return s.Send(data, to...)
+}
+
+// Recv receive a message, the length parameter is ignored
+func (s *socket) Recv(length int) (msg *api.SockMessage, err error) {
+	return s.RecvContext(context.Background(), length)
+}
+
+func (s *socket) RecvContext(ctx context.Context, length int) (msg *api.SockMessage, err error) {
+	if s.conn == nil {
+		return nil, fmt.Errorf("socket is nil")
+	}
+	var data []byte
+	if data, err = s.conn.recv(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, api.NewTimeoutError("recv", ctx.Err())
+		}
+		return nil, err
+	}
+	return &api.SockMessage{
+		Sock: s,
+		Data: data,
+		From: s.GetRemoteAddr(),
+	}, nil
+}
+
+// Close close socket, a connection waits for in flight reliable messages acknowledged (1s at most) and notify peer,
+// in background for an accepted connection. The listening socket stops accepting and keeps serving the accepted
+// connections until all of them closed
+func (s *socket) Close() (err error) {
+	if s.closed {
+		return fmt.Errorf("socket already closed")
+	}
+	if s.pc == nil {
+		return fmt.Errorf("socket is nil")
+	}
+	if s.conn != nil {
+		s.closed = true
+		s.conn.shutdown()
+		if s.accepted {
+			go s.conn.linger(lingerTimeout) //do not block server event loop
+		} else {
+			s.conn.linger(lingerTimeout)
+		}
+		return
+	}
+	return s.closeListener()
+}
+
+func (s *socket) GetLocalAddr() string {
+	if s.pc == nil {
+		return s.ui.GetHost()
+	}
+	return s.pc.LocalAddr().String()
+}
+
+func (s *socket) GetRemoteAddr() string {
+	if s.conn == nil {
+		return ""
+	}
+	return s.conn.remote.String()
+}
+
+func (s *socket) GetSocketType() types.SocketType {
+	return types.SocketType_RUDP
+}
+
+// Ping send a heartbeat ping packet
+func (s *socket) Ping() (err error) {
+	if s.conn == nil {
+		return api.ErrHeartbeatNotSupported
+	}
+	return s.conn.ping()
+}
+
+func (s *socket) GetPong() (last time.Time, rtt time.Duration) {
+	if s.conn == nil {
+		return
+	}
+	return s.conn.getPong()
+}
+
+// dispatch read packets of listening socket and deliver them to connections, a new connection created for SYN
+func (s *socket) dispatch() {
+	buf := make([]byte, packetMax)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Warnf("read from RUDP error [%v]", err.Error())
+			continue
+		}
+		p, err := decodePacket(copyBytes(buf[:n]))
+		if err != nil {
+			log.Debugf("drop packet from [%s] error [%s]", addr, err.Error())
+			continue
+		}
+		if c := s.getConn(addr, p); c != nil {
+			c.input(p)
+		}
+	}
+}
+
+// read packets of client socket from the server address
+func (s *socket) read() {
+	buf := make([]byte, packetMax)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Warnf("read from RUDP error [%v]", err.Error())
+			continue
+		}
+		if addr.String() != s.conn.remote.String() {
+			continue
+		}
+		p, err := decodePacket(copyBytes(buf[:n]))
+		if err != nil {
+			log.Debugf("drop packet from [%s] error [%s]", addr, err.Error())
+			continue
+		}
+		s.conn.input(p)
+	}
+}
+
+// getConn returns the connection of remote address, a new connection created for SYN if not closed,
+// FIN replied for the packet of unknown connection. A SYN with another ISN is a new connection of the address (eg.
+// client restarted with the same port), the former connection is reset without FIN
+func (s *socket) getConn(addr net.Addr, p *packet) *conn {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	strAddr := addr.String()
+	if c, ok := s.conns[strAddr]; ok {
+		if p.typ != packetSyn || p.seq == c.getPeerISN() {
+			return c
+		}
+		delete(s.conns, strAddr)
+		log.Warnf("RUDP connection [%s] reset by a new connection", strAddr)
+		go c.close(ErrPeerReset, false) //removeConn requires the locker
+	}
+	if p.typ != packetSyn {
+		if p.typ != packetFin {
+			_, _ = s.pc.WriteTo(encodeControl(packetFin), addr)
+		}
+		return nil
+	}
+	if s.closed {
+		return nil
+	}
+	c := newConn(s.pc, addr, s.removeConn)
+	c.establish(p.seq)
+	select {
+	case s.accepting <- c:
+		s.conns[strAddr] = c
+		return c
+	default:
+	}
+	log.Warnf("RUDP connection [%s] dropped, too many connections not accepted", strAddr)
+	go c.close(ErrConnClosed, false) //removeConn requires the locker
+	return nil
+}
+
+// removeConn remove connection and close the packet connection if socket closed and no more connection
+func (s *socket) removeConn(c *conn) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	strAddr := c.remote.String()
+	if s.conns[strAddr] == c {
+		delete(s.conns, strAddr)
+	}
+	if s.closed && len(s.conns) == 0 {
+		_ = s.pc.Close()
+	}
+}
+
+// closeListener stop accepting, close the connections not accepted yet and close the packet connection if no connection
+func (s *socket) closeListener() (err error) {
+	s.locker.Lock()
+	s.closed = true
+	close(s.quit)
+	idle := len(s.conns) == 0
+	s.locker.Unlock()
+	if idle {
+		return s.pc.Close()
+	}
+	for {
+		select {
+		case c := <-s.accepting:
+			c.close(ErrConnClosed, true)
+		default:
+			return
+		}
+	}
+}
+
+func (s *socket) listenPacket(strAddr string) (pc net.PacketConn, err error) {
+	if pc, err = net.ListenPacket(s.getNetwork(), strAddr); err != nil {
+		return nil, err
+	}
+	return newLossyConn(s.ui, pc)
+}
+
+func (s *socket) getNetwork() string {
+	if s.ui.GetScheme() == types.URL_SCHEME_RUDP6 {
+		return types.NETWORK_UDPv6
+	}
+	return types.NETWORK_UDPv4
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
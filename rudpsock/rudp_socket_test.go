@@ -0,0 +1,134 @@
+package rudpsock
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/civet148/gotools/parser"
+	"github.com/civet148/socketx/api"
+)
+
+// the reliable messages are delivered once and in order over a lossy link
+func TestLossyInOrder(t *testing.T) {
+	const query = "?loss=0.2&reorder=0.1"
+	const count = 200
+	ls := NewSocket(parser.ParseUrl("rudp://127.0.0.1:0" + query))
+	if err := ls.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+	accepted := make(chan api.Socket, 1)
+	go func() {
+		accepted <- ls.Accept()
+	}()
+
+	cs := NewSocket(parser.ParseUrl("rudp://" + ls.GetLocalAddr() + query))
+	if err := cs.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	go func() {
+		for i := 0; i < count; i++ {
+			if _, err := cs.Send([]byte(strconv.Itoa(i))); err != nil {
+				t.Errorf("send %d error %v", i, err)
+				return
+			}
+		}
+	}()
+
+	ss := <-accepted
+	if ss == nil {
+		t.Fatal("accept failed")
+	}
+	defer ss.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for i := 0; i < count; i++ {
+		msg, err := ss.RecvContext(ctx, -1)
+		if err != nil {
+			t.Fatalf("recv %d error %v", i, err)
+		}
+		if string(msg.Data) != strconv.Itoa(i) {
+			t.Fatalf("expect message %d, got %s", i, msg.Data)
+		}
+	}
+}
+
+// a client restarted with the same port (no FIN sent) is a new connection, the former one is reset
+func TestConnectSamePort(t *testing.T) {
+	ls := NewSocket(parser.ParseUrl("rudp://127.0.0.1:0"))
+	if err := ls.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+	accepted := make(chan api.Socket, 2)
+	go func() {
+		for {
+			s := ls.Accept()
+			if s == nil {
+				return
+			}
+			accepted <- s
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cs := NewSocket(parser.ParseUrl("rudp://" + ls.GetLocalAddr())).(*socket)
+	if err := cs.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := cs.Send([]byte("former")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ss := <-accepted
+	defer ss.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := ss.RecvContext(ctx, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local := cs.pc.LocalAddr().String()
+	_ = cs.pc.Close() //client crashed
+
+	pc, err := net.ListenPacket("udp4", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, _ := net.ResolveUDPAddr("udp4", ls.GetLocalAddr())
+	cs = &socket{ui: cs.ui, pc: pc}
+	cs.conn = newConn(pc, remote, func(c *conn) {
+		_ = pc.Close()
+	})
+	go cs.read()
+	if err = cs.conn.connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	if _, err = cs.Send([]byte("latter")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.RecvContext(ctx, -1); !errors.Is(err, ErrPeerReset) {
+		t.Fatalf("former connection recv returns [%v]", err)
+	}
+	var ns api.Socket
+	select {
+	case ns = <-accepted:
+	case <-ctx.Done():
+		t.Fatal("new connection not accepted")
+	}
+	defer ns.Close()
+	msg, err := ns.RecvContext(ctx, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "latter" {
+		t.Fatalf("new connection receive [%s]", msg.Data)
+	}
+}
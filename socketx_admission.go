@@ -0,0 +1,209 @@
+package socketx
+
+import (
+	"fmt"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// admission connection admission control of server
+type admission struct {
+	rejected int64 //connections rejected, accessed atomically
+	opt      *api.AdmissionOption
+	rate     *tokenBucket   //accept rate limiter, nil means unlimited
+	locker   sync.RWMutex   //locker of allow/deny lists and per IP counters
+	allow    []*net.IPNet   //allow list, empty means all allowed
+	deny     []*net.IPNet   //deny list
+	conns    map[string]int //connections of each source IP (network)
+	err      error          //option error, returned by Listen
+}
+
+func newAdmission(opt *api.AdmissionOption) *admission {
+	a := &admission{
+		opt:   opt,
+		conns: make(map[string]int),
+	}
+	if opt == nil {
+		a.opt = &api.AdmissionOption{}
+		return a
+	}
+	var err error
+	if a.allow, err = parseCIDRs(opt.Allow...); err != nil {
+		a.err = fmt.Errorf("admission allow list error [%s]", err.Error())
+	}
+	if a.deny, err = parseCIDRs(opt.Deny...); err != nil {
+		a.err = fmt.Errorf("admission deny list error [%s]", err.Error())
+	}
+	a.rate = newTokenBucket(opt.AcceptRate, opt.AcceptBurst)
+	return a
+}
+
+// SetAllowList replace the CIDR or IP allow list of admission control, empty means all allowed, the lists are
+// unchanged if any CIDR is invalid. The connections accepted are not affected
+func (w *SocketServer) SetAllowList(cidrs ...string) (err error) {
+	var nets []*net.IPNet
+	if nets, err = parseCIDRs(cidrs...); err != nil {
+		return
+	}
+	w.admission.locker.Lock()
+	defer w.admission.locker.Unlock()
+	w.admission.allow = nets
+	return
+}
+
+// SetDenyList replace the CIDR or IP deny list of admission control, the lists are unchanged if any CIDR is invalid.
+// The connections accepted are not affected
+func (w *SocketServer) SetDenyList(cidrs ...string) (err error) {
+	var nets []*net.IPNet
+	if nets, err = parseCIDRs(cidrs...); err != nil {
+		return
+	}
+	w.admission.locker.Lock()
+	defer w.admission.locker.Unlock()
+	w.admission.deny = nets
+	return
+}
+
+// GetRejectedCount returns the count of connections rejected by admission control
+func (w *SocketServer) GetRejectedCount() int64 {
+	return atomic.LoadInt64(&w.admission.rejected)
+}
+
+// admit check the connection accepted by allow/deny lists and accept rate before handshake
+func (w *SocketServer) admit(s api.Socket) bool {
+	a := w.admission
+	if ip := remoteIP(s); ip != nil {
+		if reason := a.checkIP(ip); reason != "" {
+			w.reject(s, reason)
+			return false
+		}
+	}
+	if !a.rate.allow(1) {
+		w.reject(s, "accept rate exceeded")
+		return false
+	}
+	return true
+}
+
+// admitClient check the connection count limits before the connection becomes a client, must be called in event loop
+func (w *SocketServer) admitClient(s api.Socket) bool {
+	a := w.admission
+	if a.opt.MaxConns > 0 && w.getClientCount() >= a.opt.MaxConns {
+		w.reject(s, fmt.Sprintf("max connections %d reached", a.opt.MaxConns))
+		return false
+	}
+	if a.opt.MaxConnsPerIP > 0 {
+		if key := a.ipKey(remoteIP(s)); key != "" && a.getConns(key) >= a.opt.MaxConnsPerIP {
+			w.reject(s, fmt.Sprintf("max connections %d of [%s] reached", a.opt.MaxConnsPerIP, key))
+			return false
+		}
+	}
+	return true
+}
+
+// reject close the connection rejected and count it
+func (w *SocketServer) reject(s api.Socket, reason string) {
+	atomic.AddInt64(&w.admission.rejected, 1)
+	if w.metrics != nil {
+		w.metrics.ConnRejected(s.GetSocketType())
+	}
+	log.Debugf("connection from [%s] rejected: %s", s.GetRemoteAddr(), reason)
+	_ = s.Close()
+}
+
+// checkIP returns the reason if ip is denied or not allowed, empty if ok
+func (a *admission) checkIP(ip net.IP) string {
+	a.locker.RLock()
+	defer a.locker.RUnlock()
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return fmt.Sprintf("denied by [%s]", n)
+		}
+	}
+	if len(a.allow) == 0 {
+		return ""
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return ""
+		}
+	}
+	return "not in allow list"
+}
+
+// ipKey returns the source IP network counted by MaxConnsPerIP, empty if unlimited or no IP address
+func (a *admission) ipKey(ip net.IP) string {
+	if ip == nil || a.opt.MaxConnsPerIP <= 0 {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(a.opt.GetIPv4Prefix(), 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(a.opt.GetIPv6Prefix(), 128)).String()
+}
+
+func (a *admission) getConns(key string) int {
+	a.locker.RLock()
+	defer a.locker.RUnlock()
+	return a.conns[key]
+}
+
+// addConn count a connection of source IP network key
+func (a *admission) addConn(key string) {
+	if key == "" {
+		return
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	a.conns[key]++
+}
+
+// removeConn uncount a connection of source IP network key
+func (a *admission) removeConn(key string) {
+	if key == "" {
+		return
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	if a.conns[key]--; a.conns[key] <= 0 {
+		delete(a.conns, key)
+	}
+}
+
+// remoteIP returns the IP address of remote peer, nil if not an IP address (eg. UNIX socket)
+func remoteIP(s api.Socket) net.IP {
+	addr := s.GetRemoteAddr()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+// parseCIDRs parse CIDRs or IP addresses (as a single host network)
+func parseCIDRs(cidrs ...string) (nets []*net.IPNet, err error) {
+	for _, v := range cidrs {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address [%s]", v)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		var n *net.IPNet
+		if _, n, err = net.ParseCIDR(v); err != nil {
+			return nil, fmt.Errorf("invalid CIDR [%s]", v)
+		}
+		nets = append(nets, n)
+	}
+	return
+}
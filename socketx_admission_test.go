@@ -0,0 +1,31 @@
+package socketx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/civet148/socketx/api"
+)
+
+// an invalid allow/deny list must not fail open
+func TestAdmissionInvalidCIDR(t *testing.T) {
+	for _, opt := range []*api.AdmissionOption{
+		{Allow: []string{"10.0.0.0/33"}},
+		{Deny: []string{"not an ip"}},
+	} {
+		srv := NewServer("tcp://127.0.0.1:0", api.SocketOption{Admission: opt})
+		ch := make(chan error, 1)
+		go func() {
+			ch <- srv.Listen(&SocketHandlerFuncs{})
+		}()
+		select {
+		case err := <-ch:
+			if err == nil {
+				t.Fatalf("listen with allow %v deny %v succeeded", opt.Allow, opt.Deny)
+			}
+		case <-time.After(time.Second):
+			srv.Close()
+			t.Fatalf("listen with allow %v deny %v succeeded", opt.Allow, opt.Deny)
+		}
+	}
+}
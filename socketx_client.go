@@ -1,19 +1,42 @@
 package socketx
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	"github.com/civet148/log"
 	"github.com/civet148/socketx/api"
+	_ "github.com/civet148/socketx/rudpsock" //register RUDP instance
 	_ "github.com/civet148/socketx/tcpsock"  //register TCP instance
+	"github.com/civet148/socketx/types"
 	_ "github.com/civet148/socketx/udpsock"  //register UDP instance
 	_ "github.com/civet148/socketx/unixsock" //register UNIX instance
 	_ "github.com/civet148/socketx/websock"  //register WEBSOCKET instance
+	"os"
+	"sync"
 )
 
 type SocketClient struct {
-	sock   api.Socket
-	closed bool
+	sock      api.Socket
+	closed    bool
+	url       string               //connect url
+	options   []api.SocketOption   //connect options
+	reconnect *api.ReconnectOption //auto reconnect option, nil means disabled
+	recon     *reconnector         //reconnect in progress, nil means connected
+	queue     []sendFunc           //messages queued while disconnected
+	ctx       context.Context      //reconnecting context
+	cancel    context.CancelFunc   //cancel reconnecting when client closed
+	locker    sync.RWMutex         //locker mutex
+	hb        *heartbeat           //heartbeat, nil means disabled
+	outbox    *outbox              //broadcast queue of the client accepted by server, nil means not started
+	rpc       *rpcPeer             //RPC calls and handlers, nil means RPC not used
+	metrics   api.Metrics          //metrics recorder, nil means disabled
+	stats     *clientStats         //connection statistics
+	limits    *clientLimits        //rate limits of the client accepted by server, nil means unlimited
+	addrKey   string               //source IP network of the client accepted by server counted by admission control
+	values    sync.Map             //client context values
 }
 
 func init() {
@@ -21,66 +44,305 @@ func init() {
 }
 
 func NewClient() *SocketClient {
-	return &SocketClient{}
+	return &SocketClient{
+		stats: newClientStats(),
+	}
 }
 
 // IPv4      => 		tcp://127.0.0.1:6666 [tcp4://127.0.0.1:6666]
 // WebSocket => 		ws://127.0.0.1:6668 [wss://127.0.0.1:6668]
+// RUDP      => 		rudp://127.0.0.1:6669 [rudp6://[::1]:6669]
+// UNIX      => 		unix:///tmp/unix.sock [unix://@myservice]
+// set api.SocketOption.Reconnect to reconnect automatically when connection lost (TCP/UNIX/WebSocket)
 func (w *SocketClient) Connect(url string, options ...api.SocketOption) (err error) {
+	return w.ConnectContext(context.Background(), url, options...)
+}
+
+// ConnectContext connect to url, a *api.TimeoutError returned if ctx deadline exceeded or canceled
+func (w *SocketClient) ConnectContext(ctx context.Context, url string, options ...api.SocketOption) (err error) {
 	var s api.Socket
 	if s = createSocket(url, options...); s == nil {
 		return fmt.Errorf("create socket by url [%v] failed", url)
 	}
+	if err = s.ConnectContext(ctx); err != nil {
+		return
+	}
+	w.locker.Lock()
+	hb := w.hb
+	w.hb = nil
+	w.locker.Unlock()
+	hb.stop() //heartbeat of last connection, not stopped in locker because it reads socket in locker
+	w.locker.Lock()
+	last, cancel := w.sock, w.cancel
+	if !w.closed && w.recon == nil && w.url != "" {
+		w.onDisconnected(last)
+	}
 	w.sock = s
-	return w.sock.Connect()
+	w.closed = false
+	w.recon = nil
+	w.queue = nil
+	w.url = url
+	w.options = options
+	w.reconnect, w.ctx, w.cancel = nil, nil, nil
+	if len(options) != 0 && options[0].Reconnect != nil {
+		w.reconnect = options[0].Reconnect
+		w.ctx, w.cancel = context.WithCancel(context.Background())
+	}
+	w.resetRPC()
+	w.hb = startHeartbeat(api.GetHeartbeat(options...), w.getSocket, w.onHeartbeatTimeout)
+	w.metrics = api.GetMetrics(options...)
+	w.locker.Unlock()
+	if cancel != nil {
+		cancel() //stop reconnecting of last connection
+	}
+	if last != nil {
+		_ = last.Close()
+	}
+	w.onConnected(s)
+	return
 }
 
-// only for UDP
-func (w *SocketClient) Listen(url string) (err error) {
-	if w.sock = createSocket(url); w.sock == nil {
+// only for UDP/UNIX datagram, set api.SocketOption.UDPFragment to send and receive messages larger than MTU
+func (w *SocketClient) Listen(url string, options ...api.SocketOption) (err error) {
+	if w.sock = createSocket(url, options...); w.sock == nil {
 		return fmt.Errorf("create socket by url [%v] failed", url)
 	}
+	w.metrics = api.GetMetrics(options...)
 	return w.sock.Listen()
 }
 
 func (w *SocketClient) Send(data []byte, to ...string) (n int, err error) {
-	return w.send(w.sock, data, to...)
+	if err = w.limitOut(len(data)); err != nil {
+		return
+	}
+	fn := func(s api.Socket) (int, error) {
+		return s.Send(data, to...)
+	}
+	return w.send(fn, fn)
 }
 
 func (w *SocketClient) SendJson(v interface{}, to ...string) (n int, err error) {
-	return w.sendJson(w.sock, v, to...)
+	if err = w.limitJson(v); err != nil {
+		return
+	}
+	fn := func(s api.Socket) (int, error) {
+		return s.SendJson(v, to...)
+	}
+	return w.send(fn, fn)
+}
+
+// SendText send a text message (web socket), same as Send for other socket types
+func (w *SocketClient) SendText(text string) (n int, err error) {
+	return w.SendMessage(types.MESSAGE_TYPE_TEXT, []byte(text))
+}
+
+// SendMessage send data with message type types.MESSAGE_TYPE_XXX (web socket), the message type is ignored by other
+// socket types, so a server can echo by SendMessage(msg.MsgType, msg.Data) whatever the socket type is
+func (w *SocketClient) SendMessage(msgType int, data []byte) (n int, err error) {
+	if err = w.limitOut(len(data)); err != nil {
+		return
+	}
+	fn := func(s api.Socket) (int, error) {
+		return sendMessage(s, msgType, data)
+	}
+	return w.send(fn, fn)
+}
+
+// SendUnreliable send data without retransmission and ordering (RUDP), same as Send for other socket types
+func (w *SocketClient) SendUnreliable(data []byte) (n int, err error) {
+	if err = w.limitOut(len(data)); err != nil {
+		return
+	}
+	fn := func(s api.Socket) (int, error) {
+		if us, ok := s.(api.UnreliableSender); ok {
+			return us.SendUnreliable(data)
+		}
+		return s.Send(data)
+	}
+	return w.send(fn, fn)
+}
+
+// SendFiles send data with open files (UNIX stream socket, linux), the files can be closed after sent and the peer
+// receives duplicated files by api.SockMessage.Files
+func (w *SocketClient) SendFiles(data []byte, files ...*os.File) (n int, err error) {
+	if err = w.limitOut(len(data)); err != nil {
+		return
+	}
+	fn := func(s api.Socket) (int, error) {
+		if fs, ok := s.(api.FileSender); ok {
+			return fs.SendFiles(data, files...)
+		}
+		return 0, fmt.Errorf("socket type [%s] can not send files", s.GetSocketType())
+	}
+	return w.send(fn, fn)
 }
 
 func (w *SocketClient) Recv(length int) (msg *api.SockMessage, err error) {
-	return w.recv(w.sock, length)
+	if p := w.getRPCReader(); p != nil {
+		return p.recv(context.Background())
+	}
+	return w.recv(context.Background(), func(s api.Socket) (*api.SockMessage, error) {
+		return s.Recv(length)
+	})
+}
+
+// SendContext send data, a *api.TimeoutError returned if ctx deadline exceeded or canceled
+func (w *SocketClient) SendContext(ctx context.Context, data []byte, to ...string) (n int, err error) {
+	if err = w.limitOut(len(data)); err != nil {
+		return
+	}
+	return w.send(func(s api.Socket) (int, error) {
+		return s.SendContext(ctx, data, to...)
+	}, func(s api.Socket) (int, error) {
+		return s.Send(data, to...)
+	})
+}
+
+// RecvContext receive message, a *api.TimeoutError returned if ctx deadline exceeded or canceled
+func (w *SocketClient) RecvContext(ctx context.Context, length int) (msg *api.SockMessage, err error) {
+	if p := w.getRPCReader(); p != nil {
+		return p.recv(ctx)
+	}
+	return w.recv(ctx, func(s api.Socket) (*api.SockMessage, error) {
+		return s.RecvContext(ctx, length)
+	})
 }
 
 func (w *SocketClient) GetLocalAddr() (addr string) {
-	return w.sock.GetLocalAddr()
+	return w.getSocket().GetLocalAddr()
 }
 
 func (w *SocketClient) GetRemoteAddr() (addr string) {
-	return w.sock.GetRemoteAddr()
+	return w.getSocket().GetRemoteAddr()
+}
+
+// GetTLSState returns TLS connection state, ok is false if not a TLS connection
+func (w *SocketClient) GetTLSState() (state tls.ConnectionState, ok bool) {
+	if ts, yes := w.getSocket().(api.TLSSocket); yes {
+		return ts.GetTLSState()
+	}
+	return
+}
+
+// GetPeerCertificate returns the verified certificate of peer, nil if not a TLS connection or peer not verified
+func (w *SocketClient) GetPeerCertificate() *x509.Certificate {
+	state, ok := w.GetTLSState()
+	if !ok {
+		return nil
+	}
+	return api.GetPeerCertificate(state)
+}
+
+// GetPeerIdentity returns the common name of the verified peer certificate, empty if peer not verified
+func (w *SocketClient) GetPeerIdentity() string {
+	if cert := w.GetPeerCertificate(); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// GetPeerCredential returns the PID/UID/GID of peer process (UNIX socket, linux), eg. authorize a client in OnAccept
+func (w *SocketClient) GetPeerCredential() (cred *api.PeerCredential, err error) {
+	s := w.getSocket()
+	if cs, ok := s.(api.CredentialSocket); ok {
+		return cs.GetPeerCredential()
+	}
+	return nil, fmt.Errorf("socket type [%s] has no peer credential", s.GetSocketType())
 }
 
 func (w *SocketClient) Close() (err error) {
-	return w.sock.Close()
+	w.locker.Lock()
+	connected := !w.closed && w.recon == nil && w.url != "" //the connection of client accepted by server is recorded by server
+	w.closed = true
+	if w.cancel != nil {
+		w.cancel()
+	}
+	s := w.sock
+	hb := w.hb
+	w.hb = nil
+	w.locker.Unlock()
+	hb.stop()
+	if connected {
+		w.onDisconnected(s)
+	}
+	return s.Close()
+}
+
+// Set store a value in client context by key, eg. user id after login, the values are kept for the life of the client
+func (w *SocketClient) Set(key string, value interface{}) {
+	w.values.Store(key, value)
+}
+
+// Get returns the value in client context by key, ok is false if not exist
+func (w *SocketClient) Get(key string) (value interface{}, ok bool) {
+	return w.values.Load(key)
+}
+
+// Delete remove the value in client context by key
+func (w *SocketClient) Delete(key string) {
+	w.values.Delete(key)
 }
 
 func (w *SocketClient) IsClosed() bool {
+	w.locker.RLock()
+	defer w.locker.RUnlock()
 	return w.closed
 }
 
-func (w *SocketClient) send(s api.Socket, data []byte, to ...string) (n int, err error) {
-	return s.Send(data, to...)
+func (w *SocketClient) getSocket() api.Socket {
+	w.locker.RLock()
+	defer w.locker.RUnlock()
+	return w.sock
 }
 
-func (w *SocketClient) sendJson(s api.Socket, v interface{}, to ...string) (n int, err error) {
-	return s.SendJson(v, to...)
+// onHeartbeatTimeout close the dead connection and reconnect if enabled
+func (w *SocketClient) onHeartbeatTimeout(s api.Socket) {
+	if !w.disconnected(s, ErrHeartbeatTimeout) {
+		_ = s.Close()
+	}
 }
 
-func (w *SocketClient) recv(s api.Socket, length int) (msg *api.SockMessage, err error) {
-	return s.Recv(length)
+// send by socket, the message is queued (n is 0) or ErrDisconnected returned when reconnecting, the reconnect error
+// returned if reconnecting failed
+func (w *SocketClient) send(fn, queued sendFunc) (n int, err error) {
+	w.locker.Lock()
+	if w.recon != nil {
+		defer w.locker.Unlock()
+		return 0, w.enqueue(queued)
+	}
+	s := w.sock
+	w.locker.Unlock()
+	n, err = fn(s)
+	w.onSent(s, n, err)
+	if err != nil && !api.IsTimeout(err) {
+		w.disconnected(s, err)
+	}
+	return
+}
+
+// recv by socket, wait for reconnecting if connection lost
+func (w *SocketClient) recv(ctx context.Context, fn recvFunc) (msg *api.SockMessage, err error) {
+	for {
+		s, r := w.getConnection()
+		if r != nil {
+			if err = r.wait(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		msg, err = fn(s)
+		w.onReceived(s, msg, err)
+		if err == nil || api.IsTimeout(err) || !w.disconnected(s, err) {
+			return
+		}
+	}
+}
+
+func sendMessage(s api.Socket, msgType int, data []byte) (n int, err error) {
+	if ms, ok := s.(api.MessageSender); ok {
+		return ms.SendMessage(msgType, data)
+	}
+	return s.Send(data)
 }
 
 func BasicAuth(user, password string) string {
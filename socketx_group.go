@@ -0,0 +1,238 @@
+package socketx
+
+import (
+	"fmt"
+	"github.com/civet148/log"
+	"sync"
+)
+
+// groups named groups of clients
+type groups struct {
+	locker  sync.RWMutex
+	members map[string]map[*SocketClient]bool //clients by group name
+	joined  map[*SocketClient]map[string]bool //group names by client
+}
+
+// outbox broadcast queue of a client, sent by its own goroutine
+type outbox struct {
+	queue chan []byte
+	quit  chan struct{}
+	once  sync.Once
+}
+
+func newGroups() *groups {
+	return &groups{
+		members: make(map[string]map[*SocketClient]bool),
+		joined:  make(map[*SocketClient]map[string]bool),
+	}
+}
+
+// Join add client to the named group, a client can join multiple groups and leaves all of them after OnClose called
+func (w *SocketServer) Join(client *SocketClient, group string) (err error) {
+	if client == nil || group == "" {
+		return fmt.Errorf("join client is nil or group name is empty")
+	}
+	w.lock()
+	defer w.unlock() //not removed before joined, or the groups are left by releaseClient after removed
+	if w.clients[client.sock] != client {
+		return fmt.Errorf("join client [%s] is not connected", client.GetRemoteAddr())
+	}
+	w.groups.join(client, group)
+	return
+}
+
+// Leave remove client from the named group
+func (w *SocketServer) Leave(client *SocketClient, group string) {
+	w.groups.leave(client, group)
+}
+
+// GetGroupClients returns the clients of the named group
+func (w *SocketServer) GetGroupClients(group string) []*SocketClient {
+	return w.groups.getClients(group)
+}
+
+// GetClientGroups returns the group names joined by client
+func (w *SocketServer) GetClientGroups(client *SocketClient) []string {
+	return w.groups.getGroups(client)
+}
+
+// Broadcast send data to all clients except the given ones (eg. the sender), n is the count of clients queued.
+// Each client has a broadcast queue (see api.BroadcastOption) so a slow client does not block the others, the order
+// of broadcast messages is kept for a client but not with the messages sent by Send
+func (w *SocketServer) Broadcast(data []byte, except ...*SocketClient) (n int, err error) {
+	return w.broadcast(w.getClientAll(), data, except...)
+}
+
+// BroadcastToGroup send data to the clients of the named group except the given ones (eg. the sender)
+func (w *SocketServer) BroadcastToGroup(group string, data []byte, except ...*SocketClient) (n int, err error) {
+	return w.broadcast(w.groups.getClients(group), data, except...)
+}
+
+func (w *SocketServer) broadcast(clients []*SocketClient, data []byte, except ...*SocketClient) (n int, err error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("broadcast data length is 0")
+	}
+	if w.isPacket() {
+		return 0, fmt.Errorf("broadcast not supported by packet socket, set api.SocketOption.UDPSession for UDP")
+	}
+	excluded := make(map[*SocketClient]bool, len(except))
+	for _, c := range except {
+		excluded[c] = true
+	}
+	for _, c := range clients {
+		if excluded[c] {
+			continue
+		}
+		o := w.getOutbox(c)
+		if o == nil { //client closed
+			continue
+		}
+		if o.push(data) {
+			n++
+			continue
+		}
+		if w.bcastOpt.CloseSlow {
+			log.Warnf("client [%s] broadcast queue is full, close it", c.GetRemoteAddr())
+			_ = c.sock.Close() //read error will be returned and then OnClose called
+		} else {
+			log.Warnf("client [%s] broadcast queue is full, message dropped", c.GetRemoteAddr())
+		}
+	}
+	return
+}
+
+// getOutbox returns the broadcast queue of client and start it if not started, nil returned if client removed
+func (w *SocketServer) getOutbox(c *SocketClient) *outbox {
+	w.lock()
+	defer w.unlock()
+	if w.clients[c.sock] != c {
+		return nil
+	}
+	if c.outbox == nil {
+		c.outbox = &outbox{
+			queue: make(chan []byte, w.bcastOpt.GetQueueSize()),
+			quit:  make(chan struct{}),
+		}
+		w.fanout.Add(1)
+		go w.sendOutbox(c, c.outbox)
+	}
+	return c.outbox
+}
+
+func (w *SocketServer) sendOutbox(c *SocketClient, o *outbox) {
+	defer w.fanout.Done()
+	for {
+		select {
+		case <-o.quit:
+			return
+		case data := <-o.queue:
+			if c.limitOut(len(data)) != nil {
+				continue //dropped by rate limit
+			}
+			n, err := c.sock.Send(data)
+			c.onSent(c.sock, n, err)
+			if err != nil {
+				log.Debugf("broadcast to client [%s] error [%s]", c.GetRemoteAddr(), err.Error())
+			}
+		}
+	}
+}
+
+// releaseClient record the connection closed and free the client
+func (w *SocketServer) releaseClient(c *SocketClient) {
+	if !w.isPacket() {
+		c.onDisconnected(c.sock)
+	}
+	w.freeClient(c)
+}
+
+// freeClient remove client from all groups, stop its broadcast queue and delayed messages, fail its RPC calls in flight
+func (w *SocketServer) freeClient(c *SocketClient) {
+	w.groups.leaveAll(c)
+	c.rpc.close(ErrRPCClosed)
+	c.limits.stop()
+	w.lock()
+	o := c.outbox
+	c.outbox = nil
+	w.unlock()
+	o.stop()
+}
+
+func (o *outbox) push(data []byte) bool {
+	select {
+	case o.queue <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+func (o *outbox) stop() {
+	if o == nil {
+		return
+	}
+	o.once.Do(func() {
+		close(o.quit)
+	})
+}
+
+func (g *groups) join(c *SocketClient, name string) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	if g.members[name] == nil {
+		g.members[name] = make(map[*SocketClient]bool)
+	}
+	if g.joined[c] == nil {
+		g.joined[c] = make(map[string]bool)
+	}
+	g.members[name][c] = true
+	g.joined[c][name] = true
+}
+
+func (g *groups) leave(c *SocketClient, name string) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	g.remove(c, name)
+}
+
+func (g *groups) leaveAll(c *SocketClient) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	for name := range g.joined[c] {
+		g.remove(c, name)
+	}
+}
+
+// remove client from group, the empty group is deleted
+func (g *groups) remove(c *SocketClient, name string) {
+	if members, ok := g.members[name]; ok {
+		delete(members, c)
+		if len(members) == 0 {
+			delete(g.members, name)
+		}
+	}
+	if names, ok := g.joined[c]; ok {
+		delete(names, name)
+		if len(names) == 0 {
+			delete(g.joined, c)
+		}
+	}
+}
+
+func (g *groups) getClients(name string) (clients []*SocketClient) {
+	g.locker.RLock()
+	defer g.locker.RUnlock()
+	for c := range g.members[name] {
+		clients = append(clients, c)
+	}
+	return
+}
+
+func (g *groups) getGroups(c *SocketClient) (names []string) {
+	g.locker.RLock()
+	defer g.locker.RUnlock()
+	for name := range g.joined[c] {
+		names = append(names, name)
+	}
+	return
+}
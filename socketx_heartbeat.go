@@ -0,0 +1,94 @@
+package socketx
+
+import (
+	"errors"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"sync"
+	"time"
+)
+
+var ErrHeartbeatTimeout = errors.New("socketx: heartbeat timeout")
+
+// heartbeat sends ping to peer periodically and reports the dead peer which missed N heartbeats
+type heartbeat struct {
+	opt  *api.HeartbeatOption
+	quit chan bool
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// startHeartbeat start heartbeat for the socket returned by getSocket, nil returned if heartbeat disabled
+func startHeartbeat(opt *api.HeartbeatOption, getSocket func() api.Socket, onDead func(s api.Socket)) *heartbeat {
+	if opt == nil || opt.Interval <= 0 {
+		return nil
+	}
+	h := &heartbeat{
+		opt:  opt,
+		quit: make(chan bool),
+	}
+	h.wg.Add(1)
+	go h.run(getSocket, onDead)
+	return h
+}
+
+// stop heartbeat and wait for the goroutine exited, it can be called more than once
+func (h *heartbeat) stop() {
+	if h == nil {
+		return
+	}
+	h.once.Do(func() {
+		close(h.quit)
+	})
+	h.wg.Wait()
+}
+
+func (h *heartbeat) run(getSocket func() api.Socket, onDead func(s api.Socket)) {
+	defer h.wg.Done()
+	ticker := time.NewTicker(h.opt.Interval)
+	defer ticker.Stop()
+
+	var sock api.Socket
+	var pingAt time.Time
+	var missed int
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-ticker.C:
+		}
+		s := getSocket()
+		p, ok := s.(api.Pinger)
+		if !ok {
+			log.Warnf("socket type [%v] not support heartbeat", s.GetSocketType())
+			return
+		}
+		if s != sock { //new connection
+			sock = s
+			pingAt = time.Time{}
+			missed = 0
+		}
+		if !pingAt.IsZero() {
+			if last, _ := p.GetPong(); last.Before(pingAt) {
+				missed++
+			} else {
+				missed = 0
+			}
+			if missed >= h.opt.GetMaxMissed() {
+				log.Warnf("peer [%s] missed %d heartbeats", s.GetRemoteAddr(), missed)
+				onDead(s)
+				pingAt = time.Time{}
+				missed = 0
+				continue
+			}
+		}
+		pingAt = time.Now()
+		if err := p.Ping(); err != nil {
+			if errors.Is(err, api.ErrHeartbeatNotSupported) {
+				log.Warnf("socket [%s] not support heartbeat (framer required for TCP/UNIX)", s.GetRemoteAddr())
+				return
+			}
+			log.Debugf("ping [%s] error [%s]", s.GetRemoteAddr(), err.Error())
+		}
+	}
+}
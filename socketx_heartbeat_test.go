@@ -0,0 +1,29 @@
+package socketx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/civet148/socketx/api"
+)
+
+// closing a client with heartbeat twice or connecting it again after closed must not panic
+func TestHeartbeatCloseConnect(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	srv := NewServer(listen)
+	url, _ := listenServer(t, srv, &SocketHandlerFuncs{})
+	defer srv.Close()
+
+	opt := api.SocketOption{Heartbeat: &api.HeartbeatOption{Interval: 10 * time.Millisecond}}
+	c := NewClient()
+	if err := c.Connect(url, opt); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+	_ = c.Close()
+	if err := c.Connect(url, opt); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_ = c.Close()
+}
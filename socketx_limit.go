@@ -0,0 +1,326 @@
+package socketx
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"github.com/civet148/socketx/types"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrRateLimited = errors.New("socketx: rate limit exceeded")
+
+// SocketLimitHandler is an optional interface of SocketHandler, OnLimitExceeded is called when a message of client
+// exceeded the rate limits (before the policy applied), inbound is true for the message received from client
+type SocketLimitHandler interface {
+	OnLimitExceeded(c *SocketClient, inbound bool, length int)
+}
+
+// tokenBucket token bucket refilled at rate tokens per second up to burst, nil means unlimited
+type tokenBucket struct {
+	locker sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter token buckets of messages and bytes, nil means unlimited
+type rateLimiter struct {
+	msgs  *tokenBucket
+	bytes *tokenBucket
+}
+
+// clientLimits rate limiters of a client, nil means unlimited
+type clientLimits struct {
+	policy   types.RateLimitPolicy
+	maxDelay time.Duration                  //max delay of a message by delay policy
+	in       []*rateLimiter                 //inbound limiters of client and server
+	out      []*rateLimiter                 //outbound limiters of client and server
+	exceeded func(inbound bool, length int) //limit exceeded hook
+	close    func()                         //disconnect the client
+	closed   int32                          //client disconnected by policy, the rest messages are dropped silently
+	quit     chan bool                      //closed when client closed, the messages delayed are dropped
+	once     sync.Once                      //quit once
+	exit     chan bool                      //server exit, the messages delayed are dropped
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(rate, 1)
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// refill tokens by the time elapsed, locker must be held
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// allow take n tokens if available, n larger than burst is allowed when the bucket is full
+func (b *tokenBucket) allow(n float64) bool {
+	if b == nil {
+		return true
+	}
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	b.refill()
+	if b.tokens >= n || b.tokens >= b.burst {
+		b.tokens -= n
+		return true
+	}
+	return false
+}
+
+// refund n tokens taken by allow
+func (b *tokenBucket) refund(n float64) {
+	if b == nil {
+		return
+	}
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+n)
+}
+
+// reserve take n tokens and returns the delay until they are available, nothing taken and false returned if the delay
+// exceeds max (n larger than burst is reserved when the bucket is full)
+func (b *tokenBucket) reserve(n float64, max time.Duration) (delay time.Duration, ok bool) {
+	if b == nil {
+		return 0, true
+	}
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	b.refill()
+	if b.tokens >= n || b.tokens >= b.burst {
+		b.tokens -= n
+		return 0, true
+	}
+	delay = time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+	if delay > max {
+		return 0, false
+	}
+	b.tokens -= n
+	return delay, true
+}
+
+func newRateLimiter(limit *api.RateLimit) *rateLimiter {
+	if limit == nil || (limit.Messages <= 0 && limit.Bytes <= 0) {
+		return nil
+	}
+	return &rateLimiter{
+		msgs:  newTokenBucket(limit.Messages, limit.MsgBurst),
+		bytes: newTokenBucket(limit.Bytes, limit.ByteBurst),
+	}
+}
+
+func (r *rateLimiter) allow(length int) bool {
+	if r == nil {
+		return true
+	}
+	if !r.msgs.allow(1) {
+		return false
+	}
+	if !r.bytes.allow(float64(length)) {
+		r.msgs.refund(1)
+		return false
+	}
+	return true
+}
+
+func (r *rateLimiter) refund(length int) {
+	if r == nil {
+		return
+	}
+	r.msgs.refund(1)
+	r.bytes.refund(float64(length))
+}
+
+func (r *rateLimiter) reserve(length int, max time.Duration) (delay time.Duration, ok bool) {
+	if r == nil {
+		return 0, true
+	}
+	if delay, ok = r.msgs.reserve(1, max); !ok {
+		return 0, false
+	}
+	d, ok := r.bytes.reserve(float64(length), max)
+	if !ok {
+		r.msgs.refund(1)
+		return 0, false
+	}
+	if d > delay {
+		delay = d
+	}
+	return delay, true
+}
+
+// newClientLimits create rate limiters of client with the limiters shared by server, nil returned if unlimited
+func newClientLimits(opt *api.RateLimitOption, serverIn, serverOut *rateLimiter) *clientLimits {
+	if opt == nil {
+		return nil
+	}
+	l := &clientLimits{
+		policy:   opt.Policy,
+		maxDelay: opt.GetMaxDelay(),
+		quit:     make(chan bool),
+	}
+	for _, r := range []*rateLimiter{newRateLimiter(opt.ClientIn), serverIn} {
+		if r != nil {
+			l.in = append(l.in, r)
+		}
+	}
+	for _, r := range []*rateLimiter{newRateLimiter(opt.ClientOut), serverOut} {
+		if r != nil {
+			l.out = append(l.out, r)
+		}
+	}
+	if len(l.in) == 0 && len(l.out) == 0 {
+		return nil
+	}
+	return l
+}
+
+// check the message of length bytes against the limiters, false returned if the message is dropped or the client
+// disconnected by policy
+func (l *clientLimits) check(inbound bool, length int) bool {
+	if l == nil {
+		return true
+	}
+	limiters := l.out
+	if inbound {
+		limiters = l.in
+	}
+	if l.policy == types.RateLimitPolicy_Delay {
+		var delay time.Duration
+		for i, r := range limiters {
+			d, ok := r.reserve(length, l.maxDelay)
+			if !ok { //the debt is never forgiven, a message waiting longer than max delay is dropped
+				for _, t := range limiters[:i] {
+					t.refund(length)
+				}
+				l.exceeded(inbound, length)
+				return false
+			}
+			if d > delay {
+				delay = d
+			}
+		}
+		if delay > 0 {
+			l.exceeded(inbound, length)
+			if !l.wait(delay) {
+				for _, r := range limiters {
+					r.refund(length)
+				}
+				return false
+			}
+		}
+		return true
+	}
+	if atomic.LoadInt32(&l.closed) != 0 {
+		return false
+	}
+	for i, r := range limiters {
+		if !r.allow(length) {
+			for _, t := range limiters[:i] {
+				t.refund(length)
+			}
+			if l.policy == types.RateLimitPolicy_Disconnect && !atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+				return false
+			}
+			l.exceeded(inbound, length)
+			if l.policy == types.RateLimitPolicy_Disconnect {
+				l.close()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// wait for the delay, false returned if client closed or server exited
+func (l *clientLimits) wait(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-l.quit:
+	case <-l.exit:
+	}
+	return false
+}
+
+// stop drop the messages delayed, it can be called more than once
+func (l *clientLimits) stop() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		close(l.quit)
+	})
+}
+
+// limitIn check the message received against rate limits, false returned if the message should be dropped
+func (w *SocketClient) limitIn(length int) bool {
+	if w == nil {
+		return true
+	}
+	return w.limits.check(true, length)
+}
+
+// limitOut check the message to send against rate limits, ErrRateLimited returned if the message should not be sent
+func (w *SocketClient) limitOut(length int) error {
+	if w.limits.check(false, length) {
+		return nil
+	}
+	return ErrRateLimited
+}
+
+// limitJson check the JSON message to send against rate limits
+func (w *SocketClient) limitJson(v interface{}) error {
+	if w.limits == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.limitOut(len(data))
+}
+
+// newClientLimits create the rate limiters of client accepted, the disconnect policy drops messages for a packet
+// server (the server socket can not be closed)
+func (w *SocketServer) newClientLimits(c *SocketClient) *clientLimits {
+	l := newClientLimits(w.rateLimit, w.limitIn, w.limitOut)
+	if l == nil {
+		return nil
+	}
+	if l.policy == types.RateLimitPolicy_Disconnect && w.isPacket() {
+		l.policy = types.RateLimitPolicy_Drop
+	}
+	l.exit = w.exit
+	l.exceeded = func(inbound bool, length int) {
+		log.Debugf("client [%s] rate limit exceeded (inbound %v length %d) policy [%s]", c.GetRemoteAddr(), inbound, length, l.policy)
+		if h, ok := w.handler.(SocketLimitHandler); ok {
+			h.OnLimitExceeded(c, inbound, length)
+		}
+	}
+	l.close = func() {
+		_ = c.sock.Close() //read error will be returned and then OnClose called
+	}
+	return l
+}
@@ -0,0 +1,86 @@
+package socketx
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/civet148/socketx/api"
+)
+
+func TestTokenBucketMaxDelay(t *testing.T) {
+	b := newTokenBucket(1, 1)
+	for i := 0; i < 100; i++ {
+		if d, ok := b.reserve(1, time.Second); ok && d > time.Second {
+			t.Fatalf("delay %v exceeds max delay", d)
+		}
+	}
+}
+
+// the messages passed by concurrent waiters must not exceed the rate
+func TestTokenBucketConcurrent(t *testing.T) {
+	const rate, callers = 100, 20
+	const window = 500 * time.Millisecond
+	b := newTokenBucket(rate, 1)
+	start := time.Now()
+	var passed int64
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Since(start) < window {
+				d, ok := b.reserve(1, 100*time.Millisecond)
+				if !ok {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				if time.Since(start)+d < window {
+					atomic.AddInt64(&passed, 1)
+				}
+				time.Sleep(d)
+			}
+		}()
+	}
+	wg.Wait()
+	max := int64(rate*window.Seconds()) + 5 //burst and timing slack
+	if passed > max || passed < max/2 {
+		t.Fatalf("%d messages passed in %v at rate %d/s, expect %d at most", passed, window, rate, max)
+	}
+}
+
+// the messages delayed by rate limits must not block Shutdown
+func TestRateLimitDelayShutdown(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	srv := NewServer(listen, api.SocketOption{RateLimit: &api.RateLimitOption{
+		ClientIn: &api.RateLimit{Messages: 1},
+		MaxDelay: time.Minute,
+	}})
+	url, _ := listenServer(t, srv, &SocketHandlerFuncs{})
+
+	c := NewClient()
+	if err := c.Connect(url); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		if _, err := c.Send([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		_ = srv.Shutdown(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown blocked by the messages delayed")
+	}
+}
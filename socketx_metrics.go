@@ -0,0 +1,92 @@
+package socketx
+
+import (
+	"errors"
+	"github.com/civet148/socketx/api"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// onSent record the result of sending n bytes by socket s to statistics and metrics, the hooks are nil-safe
+func (w *SocketClient) onSent(s api.Socket, n int, err error) {
+	if w == nil {
+		return
+	}
+	w.stats.sent(n, err)
+	if w.metrics == nil || s == nil {
+		return
+	}
+	if err != nil {
+		w.metrics.SendError(s.GetSocketType())
+		return
+	}
+	w.metrics.MessageOut(s.GetSocketType(), n)
+}
+
+// onReceived record the result of receiving a message by socket s, timeout and connection closed normally are ignored
+func (w *SocketClient) onReceived(s api.Socket, msg *api.SockMessage, err error) {
+	if w == nil {
+		return
+	}
+	if err == nil {
+		w.stats.received(len(msg.Data))
+	}
+	if w.metrics == nil || s == nil {
+		return
+	}
+	if err != nil {
+		if !api.IsTimeout(err) && !isClosedError(err) {
+			w.metrics.RecvError(s.GetSocketType())
+		}
+		return
+	}
+	w.metrics.MessageIn(s.GetSocketType(), len(msg.Data))
+}
+
+// onHandled record the duration of OnReceive handler started at t
+func (w *SocketClient) onHandled(s api.Socket, t time.Time) {
+	if w == nil || w.metrics == nil || s == nil {
+		return
+	}
+	w.metrics.HandlerLatency(s.GetSocketType(), time.Since(t))
+}
+
+// onConnected record a connection accepted or established
+func (w *SocketClient) onConnected(s api.Socket) {
+	if w == nil {
+		return
+	}
+	w.stats.connected()
+	if w.metrics == nil || s == nil {
+		return
+	}
+	w.metrics.ConnAccepted(s.GetSocketType())
+}
+
+// onDisconnected record a connection closed
+func (w *SocketClient) onDisconnected(s api.Socket) {
+	if w == nil || w.metrics == nil || s == nil {
+		return
+	}
+	w.metrics.ConnClosed(s.GetSocketType())
+}
+
+// isDropped returns true if err is caused by a message dropped (too large or malformed), the socket is still usable
+func isDropped(err error) bool {
+	return errors.Is(err, api.ErrMessageTooLarge) || errors.Is(err, api.ErrBadMessage)
+}
+
+// isClosedError returns true if err is caused by connection closed by peer or local, most sockets return the error
+// text only so it is also checked
+func isClosedError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, ErrClientClosed) {
+		return true
+	}
+	s := err.Error()
+	return strings.Contains(s, io.EOF.Error()) ||
+		strings.Contains(s, "use of closed network connection") ||
+		strings.Contains(s, "connection reset by peer") ||
+		strings.Contains(s, "websocket: close")
+}
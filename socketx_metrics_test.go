@@ -0,0 +1,107 @@
+package socketx
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/civet148/socketx/api"
+	"github.com/civet148/socketx/metrics"
+)
+
+// the metrics of server connections, messages and handler are scraped in Prometheus text format
+func TestMetricsScrape(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	registry := metrics.NewRegistry()
+	srv := NewServer(listen, api.SocketOption{Metrics: registry})
+	url, _ := listenServer(t, srv, &SocketHandlerFuncs{Receive: func(c *SocketClient, msg *api.SockMessage) {
+		_, _ = c.Send(msg.Data)
+	}})
+	defer srv.Close()
+
+	c := NewClient()
+	if err := c.Connect(url); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Send([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Recv(-1); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+
+	expects := []string{
+		`socketx_connections_accepted_total{type="TCP"} 1`,
+		`socketx_connections_closed_total{type="TCP"} 1`,
+		`socketx_connections_active{type="TCP"} 0`,
+		`socketx_received_messages_total{type="TCP"} 1`,
+		`socketx_received_bytes_total{type="TCP"} 5`,
+		`socketx_sent_messages_total{type="TCP"} 1`,
+		`socketx_sent_bytes_total{type="TCP"} 5`,
+		`socketx_recv_errors_total{type="TCP"} 0`,
+		`socketx_handler_duration_seconds_count{type="TCP"} 1`,
+	}
+	var body string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		rec := httptest.NewRecorder()
+		registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if body = rec.Body.String(); containsAll(body, expects) {
+			return
+		}
+	}
+	for _, v := range expects {
+		if !strings.Contains(body, v) {
+			t.Errorf("metric [%s] not found", v)
+		}
+	}
+	t.Logf("metrics scraped:\n%s", body)
+}
+
+func containsAll(s string, subs []string) bool {
+	for _, v := range subs {
+		if !strings.Contains(s, v) {
+			return false
+		}
+	}
+	return true
+}
+
+type vetoHandler struct {
+	SocketHandlerFuncs
+}
+
+func (h *vetoHandler) OnAccepting(c *SocketClient) error {
+	return errors.New("unauthorized")
+}
+
+// a connection vetoed by OnAccepting is counted as rejected only
+func TestMetricsVetoed(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0"
+	registry := metrics.NewRegistry()
+	srv := NewServer(listen, api.SocketOption{Metrics: registry})
+	url, _ := listenServer(t, srv, &vetoHandler{})
+	defer srv.Close()
+
+	c := NewClient()
+	if err := c.Connect(url); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	expects := []string{
+		`socketx_connections_accepted_total{type="TCP"} 0`,
+		`socketx_connections_closed_total{type="TCP"} 0`,
+		`socketx_connections_rejected_total{type="TCP"} 1`,
+	}
+	var body string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		rec := httptest.NewRecorder()
+		registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if body = rec.Body.String(); containsAll(body, expects) {
+			return
+		}
+	}
+	t.Fatalf("metrics scraped:\n%s", body)
+}
@@ -0,0 +1,152 @@
+package socketx
+
+import (
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps a SocketHandler with cross-cutting behaviour (logging, auth, panic recovery, metrics...), a middleware
+// can inspect or mutate the message, short-circuit by not calling next handler or close the client
+type Middleware func(next SocketHandler) SocketHandler
+
+// ReceiveFunc handle a received message
+type ReceiveFunc func(c *SocketClient, msg *api.SockMessage)
+
+// SocketHandlerFuncs adapts functions to SocketHandler, nil functions are ignored
+type SocketHandlerFuncs struct {
+	Accept  func(c *SocketClient)
+	Receive func(c *SocketClient, msg *api.SockMessage)
+	Close   func(c *SocketClient)
+}
+
+func (h *SocketHandlerFuncs) OnAccept(c *SocketClient) {
+	if h.Accept != nil {
+		h.Accept(c)
+	}
+}
+
+func (h *SocketHandlerFuncs) OnReceive(c *SocketClient, msg *api.SockMessage) {
+	if h.Receive != nil {
+		h.Receive(c, msg)
+	}
+}
+
+func (h *SocketHandlerFuncs) OnClose(c *SocketClient) {
+	if h.Close != nil {
+		h.Close(c)
+	}
+}
+
+// Chain wraps handler with middlewares, the first middleware is the outermost one
+func Chain(handler SocketHandler, middlewares ...Middleware) SocketHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// ReceiveMiddleware returns a middleware of OnReceive, call next to continue or return to drop the message
+func ReceiveMiddleware(fn func(c *SocketClient, msg *api.SockMessage, next ReceiveFunc)) Middleware {
+	return func(next SocketHandler) SocketHandler {
+		return &receiveHandler{SocketHandler: next, fn: fn}
+	}
+}
+
+// AcceptMiddleware returns a middleware of OnAccept, call next to continue or return (eg. close client after
+// authorization failed) to skip OnAccept of next handler, OnClose is always called when the client disconnected
+func AcceptMiddleware(fn func(c *SocketClient, next func(c *SocketClient))) Middleware {
+	return func(next SocketHandler) SocketHandler {
+		return &acceptHandler{SocketHandler: next, fn: fn}
+	}
+}
+
+// Recovery returns a middleware which recovers panics of next handler, the panic is logged with stack and
+// passed to the handlers (eg. close the client), the client keeps connected if no handler closed it
+func Recovery(handlers ...func(c *SocketClient, v interface{})) Middleware {
+	return func(next SocketHandler) SocketHandler {
+		return &recoveryHandler{next: next, handlers: handlers}
+	}
+}
+
+type receiveHandler struct {
+	SocketHandler
+	fn func(c *SocketClient, msg *api.SockMessage, next ReceiveFunc)
+}
+
+func (h *receiveHandler) OnReceive(c *SocketClient, msg *api.SockMessage) {
+	h.fn(c, msg, h.SocketHandler.OnReceive)
+}
+
+type acceptHandler struct {
+	SocketHandler
+	fn func(c *SocketClient, next func(c *SocketClient))
+}
+
+func (h *acceptHandler) OnAccept(c *SocketClient) {
+	h.fn(c, h.SocketHandler.OnAccept)
+}
+
+type recoveryHandler struct {
+	next     SocketHandler
+	handlers []func(c *SocketClient, v interface{})
+}
+
+func (h *recoveryHandler) OnAccept(c *SocketClient) {
+	defer h.recover(c, "OnAccept")
+	h.next.OnAccept(c)
+}
+
+func (h *recoveryHandler) OnReceive(c *SocketClient, msg *api.SockMessage) {
+	defer h.recover(c, "OnReceive")
+	h.next.OnReceive(c, msg)
+}
+
+func (h *recoveryHandler) OnClose(c *SocketClient) {
+	defer h.recover(c, "OnClose")
+	h.next.OnClose(c)
+}
+
+func (h *recoveryHandler) recover(c *SocketClient, event string) {
+	if v := recover(); v != nil {
+		log.Errorf("%s of client [%s] panic [%v]\n%s", event, c.GetRemoteAddr(), v, debug.Stack())
+		for _, fn := range h.handlers {
+			fn(c, v)
+		}
+	}
+}
+
+// Use append middlewares of server handler, it must be called before Listen
+func (w *SocketServer) Use(middlewares ...Middleware) {
+	w.lock()
+	defer w.unlock()
+	w.middleware = append(w.middleware, middlewares...)
+}
+
+// Serve read messages and call handler (wrapped by middlewares) until the connection closed: OnAccept is called once
+// before reading, OnReceive for each message and OnClose after connection closed. The messages are not available by
+// Recv while serving, nil returned if the client was closed by Close
+func (w *SocketClient) Serve(handler SocketHandler, middlewares ...Middleware) (err error) {
+	h := Chain(handler, middlewares...)
+	h.OnAccept(w)
+	defer h.OnClose(w)
+	for {
+		var msg *api.SockMessage
+		msg, err = w.Recv(-1)
+		if isDropped(err) {
+			continue //message dropped, the socket is still usable
+		}
+		if err != nil {
+			if w.IsClosed() {
+				err = nil
+			}
+			return
+		}
+		if len(msg.Data) > 0 {
+			t := time.Now()
+			h.OnReceive(w, msg)
+			w.onHandled(msg.Sock, t)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package socketx
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/civet148/socketx/api"
+)
+
+// the middlewares run in order of Use, a middleware not calling next drops the message, Recovery keeps the client
+// connected after the handler panicked
+func TestMiddlewareChain(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	trace := make(chan string, 16)
+	recovered := make(chan interface{}, 1)
+	tracer := func(name string) Middleware {
+		return ReceiveMiddleware(func(c *SocketClient, msg *api.SockMessage, next ReceiveFunc) {
+			trace <- name
+			next(c, msg)
+		})
+	}
+	srv := NewServer(listen)
+	srv.Use(
+		Recovery(func(c *SocketClient, v interface{}) { recovered <- v }),
+		tracer("first"),
+		ReceiveMiddleware(func(c *SocketClient, msg *api.SockMessage, next ReceiveFunc) {
+			if !strings.HasPrefix(string(msg.Data), "drop") {
+				next(c, msg)
+			}
+		}),
+		tracer("second"),
+	)
+	url, _ := listenServer(t, srv, &SocketHandlerFuncs{Receive: func(c *SocketClient, msg *api.SockMessage) {
+		if string(msg.Data) == "panic" {
+			panic("boom")
+		}
+		trace <- "handler:" + string(msg.Data)
+	}})
+	defer srv.Close()
+
+	c := NewClient()
+	if err := c.Connect(url); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	expect := func(want ...string) {
+		for _, w := range want {
+			select {
+			case got := <-trace:
+				if got != w {
+					t.Fatalf("middleware trace [%s], want [%s]", got, w)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("middleware trace [%s] not received", w)
+			}
+		}
+	}
+	_, _ = c.Send([]byte("hello"))
+	expect("first", "second", "handler:hello")
+	_, _ = c.Send([]byte("drop"))
+	expect("first")
+	_, _ = c.Send([]byte("panic"))
+	expect("first", "second")
+	select {
+	case v := <-recovered:
+		if v != "boom" {
+			t.Fatalf("recovered [%v], want [boom]", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic not recovered")
+	}
+	_, _ = c.Send([]byte("again"))
+	expect("first", "second", "handler:again")
+	if len(trace) != 0 {
+		t.Fatalf("unexpected middleware trace [%s]", <-trace)
+	}
+}
+
+// a client serving with AcceptMiddleware skips OnAccept of next handler if next not called, OnClose is still called
+func TestAcceptMiddleware(t *testing.T) {
+	events := make(chan string, 4)
+	h := Chain(&SocketHandlerFuncs{
+		Accept: func(c *SocketClient) { events <- "accept" },
+		Close:  func(c *SocketClient) { events <- "close" },
+	}, AcceptMiddleware(func(c *SocketClient, next func(c *SocketClient)) {
+		events <- "auth"
+	}))
+	c := NewClient()
+	h.OnAccept(c)
+	h.OnClose(c)
+	close(events)
+	var got []string
+	for e := range events {
+		got = append(got, e)
+	}
+	if strings.Join(got, ",") != "auth,close" {
+		t.Fatalf("events [%s], want [auth,close]", strings.Join(got, ","))
+	}
+}
@@ -0,0 +1,216 @@
+package socketx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	reconnectMinBackoff = 1 * time.Second
+	reconnectMaxBackoff = 30 * time.Second
+	reconnectFactor     = 2
+	reconnectJitter     = 0.2
+)
+
+var (
+	ErrDisconnected  = errors.New("socketx: client disconnected")
+	ErrSendQueueFull = errors.New("socketx: send queue is full")
+	ErrClientClosed  = errors.New("socketx: client closed")
+)
+
+type sendFunc func(s api.Socket) (n int, err error)
+type recvFunc func(s api.Socket) (msg *api.SockMessage, err error)
+
+// reconnector is a reconnecting in progress, or failed if done closed with err (the client fails until connected again)
+type reconnector struct {
+	done    chan bool            //closed when reconnecting finished
+	err     error                //reconnecting error, nil means reconnected
+	ctx     context.Context      //canceled when client closed or connected again
+	url     string               //connect url
+	options []api.SocketOption   //connect options
+	opt     *api.ReconnectOption //auto reconnect option
+}
+
+func (r *reconnector) wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return api.NewTimeoutError("reconnect", ctx.Err())
+	}
+}
+
+// failed returns true if reconnecting gave up
+func (r *reconnector) failed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *SocketClient) getConnection() (s api.Socket, r *reconnector) {
+	w.locker.RLock()
+	defer w.locker.RUnlock()
+	return w.sock, w.recon
+}
+
+// enqueue a message while reconnecting, locker must be held
+func (w *SocketClient) enqueue(fn sendFunc) error {
+	if w.recon.failed() {
+		return w.recon.err
+	}
+	if w.recon.opt.QueueSize <= 0 {
+		return ErrDisconnected
+	}
+	if len(w.queue) >= w.recon.opt.QueueSize {
+		return ErrSendQueueFull
+	}
+	w.queue = append(w.queue, fn)
+	return nil
+}
+
+// disconnected start reconnecting if socket s is the current connection, false returned if client closed or auto
+// reconnect disabled
+func (w *SocketClient) disconnected(s api.Socket, err error) bool {
+	w.locker.Lock()
+	if w.closed || w.reconnect == nil {
+		w.locker.Unlock()
+		return false
+	}
+	if w.sock != s || w.recon != nil {
+		w.locker.Unlock()
+		return true
+	}
+	r := &reconnector{
+		done:    make(chan bool),
+		ctx:     w.ctx,
+		url:     w.url,
+		options: w.options,
+		opt:     w.reconnect,
+	}
+	w.recon = r
+	w.locker.Unlock()
+
+	_ = s.Close()
+	w.onDisconnected(s)
+	log.Warnf("connection to [%s] lost with error [%v], reconnecting...", r.url, err)
+	if r.opt.OnDisconnected != nil {
+		r.opt.OnDisconnected(err)
+	}
+	go w.reconnecting(r)
+	return true
+}
+
+func (w *SocketClient) reconnecting(r *reconnector) {
+	var err error
+	var opt = r.opt
+	for attempt := 1; opt.MaxAttempts <= 0 || attempt <= opt.MaxAttempts; attempt++ {
+		select {
+		case <-r.ctx.Done():
+			w.finishReconnect(r, ErrClientClosed)
+			return
+		case <-time.After(reconnectBackoff(opt, attempt)):
+		}
+		var s api.Socket
+		if s = createSocket(r.url, r.options...); s == nil {
+			err = fmt.Errorf("create socket by url [%v] failed", r.url)
+			continue
+		}
+		if err = s.ConnectContext(r.ctx); err != nil {
+			log.Warnf("reconnect to [%s] attempt %d failed with error [%v]", r.url, attempt, err)
+			continue
+		}
+		if opt.OnReconnected != nil {
+			opt.OnReconnected(s)
+		}
+		if err = w.flush(s, r); err != nil {
+			log.Warnf("reconnect to [%s] attempt %d flush queue error [%v]", r.url, attempt, err)
+			_ = s.Close()
+			continue
+		}
+		log.Infof("reconnect to [%s] ok after %d attempt(s)", r.url, attempt)
+		return
+	}
+	w.finishReconnect(r, fmt.Errorf("reconnect to [%s] failed after %d attempts, last error [%w]", r.url, opt.MaxAttempts, err))
+}
+
+// flush the queued messages by new socket s and then make it the current connection
+func (w *SocketClient) flush(s api.Socket, r *reconnector) (err error) {
+	for {
+		w.locker.Lock()
+		if w.closed || w.recon != r { //closed or connected again by ConnectContext
+			w.locker.Unlock()
+			return ErrClientClosed
+		}
+		if len(w.queue) == 0 {
+			w.sock = s
+			w.recon = nil
+			w.locker.Unlock()
+			w.onConnected(s)
+			close(r.done)
+			return nil
+		}
+		fn := w.queue[0]
+		w.locker.Unlock()
+
+		if _, err = fn(s); err != nil {
+			return err
+		}
+		w.locker.Lock()
+		w.queue = w.queue[1:]
+		w.locker.Unlock()
+	}
+}
+
+// finishReconnect give up reconnecting, the queued messages are dropped and the client fails with err (Send/Recv
+// return it without reconnecting again) until connected again by ConnectContext
+func (w *SocketClient) finishReconnect(r *reconnector, err error) {
+	var hb *heartbeat
+	w.locker.Lock()
+	if w.recon == r {
+		if len(w.queue) != 0 {
+			log.Warnf("drop %d queued message(s) for [%s]", len(w.queue), r.url)
+		}
+		w.queue = nil
+		hb = w.hb
+		w.hb = nil
+	} else {
+		err = nil //connected again by ConnectContext, the waiters use the new connection
+	}
+	r.err = err
+	w.locker.Unlock()
+	close(r.done)
+	hb.stop()
+}
+
+// reconnectBackoff returns the delay before reconnect attempt (starts from 1)
+func reconnectBackoff(opt *api.ReconnectOption, attempt int) time.Duration {
+	var min, max = opt.MinBackoff, opt.MaxBackoff
+	var factor, jitter = opt.Factor, opt.Jitter
+	if min <= 0 {
+		min = reconnectMinBackoff
+	}
+	if max <= 0 {
+		max = reconnectMaxBackoff
+	}
+	if factor < 1 {
+		factor = reconnectFactor
+	}
+	if jitter <= 0 || jitter > 1 {
+		jitter = reconnectJitter
+	}
+	delay := float64(min) * math.Pow(factor, float64(attempt-1))
+	if delay > float64(max) {
+		delay = float64(max)
+	}
+	delay += delay * jitter * (rand.Float64()*2 - 1)
+	return time.Duration(delay)
+}
@@ -0,0 +1,159 @@
+package socketx
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/civet148/socketx/api"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	opt := &api.ReconnectOption{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Factor: 2, Jitter: 0.1}
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{10, time.Second},
+	}
+	for _, c := range cases {
+		for i := 0; i < 100; i++ {
+			d := reconnectBackoff(opt, c.attempt)
+			if d < c.want*9/10 || d > c.want*11/10 {
+				t.Fatalf("attempt %d backoff %v out of %v +/- 10%%", c.attempt, d, c.want)
+			}
+		}
+	}
+	if d := reconnectBackoff(&api.ReconnectOption{}, 1); d < reconnectMinBackoff*8/10 || d > reconnectMinBackoff*12/10 {
+		t.Fatalf("default backoff %v out of %v +/- 20%%", d, reconnectMinBackoff)
+	}
+}
+
+// the messages sent while reconnecting are queued and flushed after the messages sent in OnReconnected
+func TestReconnectQueue(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	received := make(chan string, 16)
+	srv := NewServer(listen)
+	url, _ := listenServer(t, srv, &SocketHandlerFuncs{Receive: func(c *SocketClient, msg *api.SockMessage) {
+		received <- string(msg.Data)
+		if string(msg.Data) == "bye" {
+			_ = c.Close()
+		}
+	}})
+	defer srv.Close()
+
+	disconnected := make(chan error, 1)
+	c := NewClient()
+	err := c.Connect(url, api.SocketOption{Reconnect: &api.ReconnectOption{
+		MinBackoff: 300 * time.Millisecond,
+		QueueSize:  2,
+		OnDisconnected: func(err error) {
+			disconnected <- err
+		},
+		OnReconnected: func(s api.Socket) {
+			_, _ = s.Send([]byte("login"))
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	go func() {
+		for {
+			if _, err := c.Recv(-1); err != nil {
+				return
+			}
+		}
+	}()
+	if _, err = c.Send([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("disconnection not detected")
+	}
+	for _, s := range []string{"q1", "q2"} {
+		if n, err := c.Send([]byte(s)); n != 0 || err != nil {
+			t.Fatalf("send [%s] while reconnecting returns %d [%v], not queued", s, n, err)
+		}
+	}
+	if _, err = c.Send([]byte("q3")); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("send to a full queue returns [%v]", err)
+	}
+	for _, want := range []string{"bye", "login", "q1", "q2"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("server received [%s], want [%s]", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("server not received [%s]", want)
+		}
+	}
+}
+
+// the client fails after MaxAttempts without reconnecting again until connected again
+func TestReconnectFailed(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	srv := NewServer(listen)
+	url, _ := listenServer(t, srv, &SocketHandlerFuncs{})
+
+	disconnected := make(chan error, 4)
+	c := NewClient()
+	err := c.Connect(url, api.SocketOption{Reconnect: &api.ReconnectOption{
+		MinBackoff:  20 * time.Millisecond,
+		MaxBackoff:  20 * time.Millisecond,
+		MaxAttempts: 2,
+		QueueSize:   10,
+		OnDisconnected: func(err error) {
+			disconnected <- err
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	srv.Close()
+
+	recvErr := make(chan error, 1)
+	go func() {
+		_, err := c.Recv(-1)
+		recvErr <- err
+	}()
+	select {
+	case err = <-recvErr:
+		if err == nil {
+			t.Fatal("recv succeeded after server closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("recv not failed after reconnecting gave up")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Send([]byte("hello")); err == nil || errors.Is(err, ErrDisconnected) {
+			t.Fatalf("send after reconnecting gave up returns [%v]", err)
+		}
+		if _, err := c.Recv(-1); err == nil {
+			t.Fatal("recv after reconnecting gave up succeeded")
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := len(disconnected); n != 1 {
+		t.Fatalf("%d disconnection(s) reported, reconnecting started again after failed", n)
+	}
+
+	srv = NewServer(listen)
+	url, _ = listenServer(t, srv, &SocketHandlerFuncs{})
+	defer srv.Close()
+	if err = c.Connect(url); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Send([]byte("hello")); err != nil {
+		t.Fatalf("send after connected again returns [%v]", err)
+	}
+}
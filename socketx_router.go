@@ -0,0 +1,166 @@
+package socketx
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"reflect"
+	"sync"
+)
+
+// RouteMessage message dispatched by Router
+type RouteMessage struct {
+	*api.SockMessage
+	Key     string                                 //route key extracted
+	Payload []byte                                 //payload extracted
+	decode  func(data []byte, v interface{}) error //payload decoder of router
+}
+
+// Bind decode payload to v by the decoder of router (JSON by default)
+func (m *RouteMessage) Bind(v interface{}) error {
+	return m.decode(m.Payload, v)
+}
+
+// RouteHandler handle the messages of a route key
+type RouteHandler func(c *SocketClient, msg *RouteMessage)
+
+// Router is a SocketHandler which dispatches messages to the handlers registered by route key (message type), the
+// route key and payload are extracted by api.RouteExtractor (JSON field, first N bytes or integer header), the
+// fallback handler is called for unknown route keys or messages failed to extract
+type Router struct {
+	extractor api.RouteExtractor
+	decoder   func(data []byte, v interface{}) error
+	locker    sync.RWMutex
+	routes    map[string]RouteHandler
+	fallback  RouteHandler
+	onAccept  func(c *SocketClient)
+	onClose   func(c *SocketClient)
+}
+
+func NewRouter(extractor api.RouteExtractor) *Router {
+	return &Router{
+		extractor: extractor,
+		decoder:   json.Unmarshal,
+		routes:    make(map[string]RouteHandler),
+	}
+}
+
+// SetDecoder set payload decoder of RouteMessage.Bind and HandleDecoded, default json.Unmarshal
+func (r *Router) SetDecoder(decoder func(data []byte, v interface{}) error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.decoder = decoder
+}
+
+// Handle register handler of route key
+func (r *Router) Handle(key string, fn RouteHandler) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.routes[key] = fn
+}
+
+// HandleDecoded register a function of func(c *SocketClient, v *T) or func(c *SocketClient, v T) for route key,
+// the payload is decoded to a new T, the fallback handler is called if decoding failed. It panics if fn is not
+// a function of the signatures above
+func (r *Router) HandleDecoded(key string, fn interface{}) {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func || ft.NumIn() != 2 || ft.NumOut() != 0 || ft.In(0) != reflect.TypeOf((*SocketClient)(nil)) {
+		panic(fmt.Sprintf("route [%s] handler must be func(c *socketx.SocketClient, v *T), got %s", key, ft))
+	}
+	typ := ft.In(1)
+	ptr := typ.Kind() == reflect.Ptr
+	if ptr {
+		typ = typ.Elem()
+	}
+	r.Handle(key, func(c *SocketClient, msg *RouteMessage) {
+		v := reflect.New(typ)
+		if err := msg.Bind(v.Interface()); err != nil {
+			log.Warnf("route [%s] decode payload from [%s] error [%s]", msg.Key, c.GetRemoteAddr(), err.Error())
+			r.getFallback()(c, msg)
+			return
+		}
+		if !ptr {
+			v = v.Elem()
+		}
+		fv.Call([]reflect.Value{reflect.ValueOf(c), v})
+	})
+}
+
+// Fallback set the handler of unknown route keys and messages failed to extract (Key is empty), the messages are
+// dropped with a warning by default
+func (r *Router) Fallback(fn RouteHandler) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.fallback = fn
+}
+
+// HandleAccept set the handler called by OnAccept
+func (r *Router) HandleAccept(fn func(c *SocketClient)) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.onAccept = fn
+}
+
+// HandleClose set the handler called by OnClose
+func (r *Router) HandleClose(fn func(c *SocketClient)) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.onClose = fn
+}
+
+func (r *Router) OnAccept(c *SocketClient) {
+	r.locker.RLock()
+	fn := r.onAccept
+	r.locker.RUnlock()
+	if fn != nil {
+		fn(c)
+	}
+}
+
+// OnReceive extract route key and dispatch the message, a client can also dispatch the messages received by it
+func (r *Router) OnReceive(c *SocketClient, msg *api.SockMessage) {
+	r.locker.RLock()
+	decoder := r.decoder
+	r.locker.RUnlock()
+	rm := &RouteMessage{
+		SockMessage: msg,
+		decode:      decoder,
+	}
+	key, payload, err := r.extractor.Extract(msg.Data)
+	if err != nil {
+		log.Warnf("route message from [%s] error [%s]", c.GetRemoteAddr(), err.Error())
+		rm.Payload = msg.Data
+		r.getFallback()(c, rm)
+		return
+	}
+	rm.Key, rm.Payload = key, payload
+	r.locker.RLock()
+	fn, ok := r.routes[key]
+	r.locker.RUnlock()
+	if !ok {
+		fn = r.getFallback()
+	}
+	fn(c, rm)
+}
+
+func (r *Router) OnClose(c *SocketClient) {
+	r.locker.RLock()
+	fn := r.onClose
+	r.locker.RUnlock()
+	if fn != nil {
+		fn(c)
+	}
+}
+
+func (r *Router) getFallback() RouteHandler {
+	r.locker.RLock()
+	defer r.locker.RUnlock()
+	if r.fallback != nil {
+		return r.fallback
+	}
+	return func(c *SocketClient, msg *RouteMessage) {
+		log.Warnf("route [%s] not found, message from [%s] dropped", msg.Key, c.GetRemoteAddr())
+	}
+}
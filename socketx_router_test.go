@@ -0,0 +1,81 @@
+package socketx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/civet148/socketx/api"
+)
+
+type routeLogin struct {
+	Name string `json:"name"`
+}
+
+// the messages are dispatched by route key, unknown keys, messages failed to extract or decode go to fallback
+func TestRouterDispatch(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	routed := make(chan string, 16)
+	r := NewRouter(api.NewJSONFieldExtractor("type", "data"))
+	r.Handle("echo", func(c *SocketClient, msg *RouteMessage) {
+		routed <- "echo:" + string(msg.Payload)
+	})
+	r.HandleDecoded("login", func(c *SocketClient, v *routeLogin) {
+		routed <- "login:" + v.Name
+	})
+	r.HandleDecoded("logout", func(c *SocketClient, v routeLogin) {
+		routed <- "logout:" + v.Name
+	})
+	r.Fallback(func(c *SocketClient, msg *RouteMessage) {
+		routed <- "fallback:" + msg.Key
+	})
+	srv := NewServer(listen)
+	url, _ := listenServer(t, srv, r)
+	defer srv.Close()
+
+	c := NewClient()
+	if err := c.Connect(url); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cases := []struct {
+		msg  string
+		want string
+	}{
+		{`{"type":"echo","data":"hi"}`, `echo:"hi"`},
+		{`{"type":"login","data":{"name":"alice"}}`, "login:alice"},
+		{`{"type":"logout","data":{"name":"bob"}}`, "logout:bob"},
+		{`{"type":"login","data":"alice"}`, "fallback:login"},
+		{`{"type":"unknown"}`, "fallback:unknown"},
+		{`not json`, "fallback:"},
+	}
+	for _, cs := range cases {
+		if _, err := c.Send([]byte(cs.msg)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-routed:
+			if got != cs.want {
+				t.Fatalf("message [%s] routed to [%s], want [%s]", cs.msg, got, cs.want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message [%s] not routed", cs.msg)
+		}
+	}
+}
+
+func TestRouterHandleDecodedPanic(t *testing.T) {
+	for _, fn := range []interface{}{
+		func(v *routeLogin) {},
+		func(c *SocketClient, v *routeLogin) error { return nil },
+		"not a function",
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("HandleDecoded with %T not panic", fn)
+				}
+			}()
+			NewRouter(api.NewPrefixExtractor(1)).HandleDecoded("key", fn)
+		}()
+	}
+}
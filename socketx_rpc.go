@@ -0,0 +1,476 @@
+package socketx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"github.com/civet148/socketx/types"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const rpcVersion = "2.0"
+
+// JSON-RPC 2.0 error codes
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	RPCServerError    = -32000 // error returned by method handler
+)
+
+var ErrRPCClosed = errors.New("socketx: rpc connection closed")
+
+// RPCError error of a call returned by peer, a method handler returns *RPCError to reply a specified error code
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error [%d] %s", e.Code, e.Message)
+}
+
+// RPCRequest request of a call received
+type RPCRequest struct {
+	Method string          //method name
+	Params json.RawMessage //JSON encoded params, nil if no params
+}
+
+// Bind decode params to v, an invalid params error returned if failed
+func (r *RPCRequest) Bind(v interface{}) (err error) {
+	if len(r.Params) == 0 {
+		return &RPCError{Code: RPCInvalidParams, Message: "params required"}
+	}
+	if err = json.Unmarshal(r.Params, v); err != nil {
+		return &RPCError{Code: RPCInvalidParams, Message: err.Error()}
+	}
+	return
+}
+
+// RPCHandler handle a call, the result is JSON encoded and replied to caller, an error is replied as *RPCError
+type RPCHandler func(c *SocketClient, req *RPCRequest) (result interface{}, err error)
+
+// rpcMessage JSON-RPC 2.0 request (method not empty) or response
+type rpcMessage struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	err     error           //connection closed before response received
+}
+
+// rpcMethods registered method handlers
+type rpcMethods struct {
+	locker   sync.RWMutex
+	handlers map[string]RPCHandler
+}
+
+// rpcPeer calls in flight and method handlers of a connection
+type rpcPeer struct {
+	locker  sync.Mutex
+	seq     uint64                      //last call id
+	pending map[string]chan *rpcMessage //calls waiting for response by id
+	methods *rpcMethods                 //method handlers, shared by the clients of a server
+	server  bool                        //client accepted by server, messages read by server
+	err     error                       //connection closed error
+	inbox   chan *api.SockMessage       //messages not RPC read by client reader, nil if reader not started
+	done    chan struct{}               //closed when client reader exited or peer replaced by ConnectContext
+}
+
+func newRPCMethods() *rpcMethods {
+	return &rpcMethods{
+		handlers: make(map[string]RPCHandler),
+	}
+}
+
+func newRPCPeer(methods *rpcMethods, server bool) *rpcPeer {
+	return &rpcPeer{
+		pending: make(map[string]chan *rpcMessage),
+		methods: methods,
+		server:  server,
+	}
+}
+
+// HandleRPC register a method handler for the calls of all clients, the handlers run concurrently. The requests pass
+// through the middlewares of server (see Use) before dispatched
+func (w *SocketServer) HandleRPC(method string, fn RPCHandler) {
+	w.rpcMethods.add(method, fn)
+}
+
+// HandleRPC register a method handler for the calls from server (client side), RPC messages are read in background
+// after HandleRPC or Call called, Recv returns the other messages (up to 1024 queued, the reader waits for Recv when
+// the queue is full)
+func (w *SocketClient) HandleRPC(method string, fn RPCHandler) {
+	p := w.getRPC()
+	p.methods.add(method, fn)
+	if !p.server {
+		w.startRPC(p)
+	}
+}
+
+// Call send a JSON-RPC 2.0 request and wait for the response decoded to resp (nil means result ignored). Many calls
+// can be in flight on a connection, a *api.TimeoutError returned if ctx deadline exceeded or canceled and a *RPCError
+// returned if peer replied an error. It works on a client connected (call server) or a client accepted by server
+// (call client), a framer is required for TCP/UNIX stream sockets
+func (w *SocketClient) Call(ctx context.Context, method string, req interface{}, resp interface{}) (err error) {
+	p := w.getRPC()
+	if !p.server {
+		w.startRPC(p)
+	}
+	var params []byte
+	if req != nil {
+		if params, err = json.Marshal(req); err != nil {
+			return log.Errorf("marshal params of [%s] error [%s]", method, err.Error())
+		}
+	}
+	id, ch, err := p.register()
+	if err != nil {
+		return err
+	}
+	defer p.unregister(id)
+	data, err := json.Marshal(&rpcMessage{
+		Version: rpcVersion,
+		ID:      json.RawMessage(id),
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return log.Errorf("marshal request of [%s] error [%s]", method, err.Error())
+	}
+	if _, err = w.SendMessage(types.MESSAGE_TYPE_TEXT, data); err != nil {
+		return err
+	}
+	select {
+	case m := <-ch:
+		if m.err != nil {
+			return m.err
+		}
+		if m.Error != nil {
+			return m.Error
+		}
+		if resp != nil && len(m.Result) != 0 {
+			if err = json.Unmarshal(m.Result, resp); err != nil {
+				return log.Errorf("unmarshal result of [%s] error [%s]", method, err.Error())
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		return api.NewTimeoutError("call", ctx.Err())
+	}
+}
+
+func (w *SocketClient) getRPC() *rpcPeer {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	if w.rpc == nil {
+		w.rpc = newRPCPeer(newRPCMethods(), false)
+	}
+	return w.rpc
+}
+
+// getRPCReader returns the RPC peer if the client reader started
+func (w *SocketClient) getRPCReader() *rpcPeer {
+	w.locker.RLock()
+	p := w.rpc
+	w.locker.RUnlock()
+	if p == nil {
+		return nil
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.inbox == nil {
+		return nil
+	}
+	return p
+}
+
+// startRPC start client reader to read responses and requests, the other messages are queued for Recv
+func (w *SocketClient) startRPC(p *rpcPeer) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.inbox != nil {
+		return
+	}
+	p.inbox = make(chan *api.SockMessage, 1024)
+	p.done = make(chan struct{})
+	go w.readRPC(p)
+}
+
+// resetRPC replace the RPC peer of last connection by a new one keeping the method handlers, the calls in flight fail
+// with ErrRPCClosed and the client reader is restarted for the new connection, locker must be held
+func (w *SocketClient) resetRPC() {
+	p := w.rpc
+	if p == nil || p.server {
+		return
+	}
+	w.rpc = newRPCPeer(p.methods, false)
+	if p.started() {
+		w.startRPC(w.rpc)
+	}
+	p.close(ErrRPCClosed)
+}
+
+// getRPCConnection returns the connection read by client reader of p, ok is false if p was replaced by ConnectContext
+func (w *SocketClient) getRPCConnection(p *rpcPeer) (s api.Socket, r *reconnector, ok bool) {
+	w.locker.RLock()
+	defer w.locker.RUnlock()
+	return w.sock, w.recon, w.rpc == p
+}
+
+// readRPC read the connection like recv until p replaced by ConnectContext, so the reader of last connection never
+// reads the new one
+func (w *SocketClient) readRPC(p *rpcPeer) {
+	for {
+		s, r, ok := w.getRPCConnection(p)
+		if !ok {
+			return
+		}
+		if r != nil {
+			if err := r.wait(context.Background()); err != nil {
+				p.close(err)
+				return
+			}
+			continue
+		}
+		msg, err := s.Recv(-1)
+		w.onReceived(s, msg, err)
+		if err != nil {
+			if !api.IsTimeout(err) && w.disconnected(s, err) {
+				continue
+			}
+			p.close(err)
+			return
+		}
+		if req, ok := p.dispatch(msg); ok {
+			if req != nil {
+				go w.serveRPC(req)
+			}
+			continue
+		}
+		select {
+		case p.inbox <- msg: //wait for Recv when the queue is full
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// recv a message not RPC read by client reader
+func (p *rpcPeer) recv(ctx context.Context) (msg *api.SockMessage, err error) {
+	select {
+	case msg = <-p.inbox:
+		return msg, nil
+	default:
+	}
+	select {
+	case msg = <-p.inbox:
+		return msg, nil
+	case <-p.done:
+		return nil, p.getError()
+	case <-ctx.Done():
+		return nil, api.NewTimeoutError("recv", ctx.Err())
+	}
+}
+
+// dispatchRPC complete the call waiting for a response, the request returned should be served by serveRPC. ok is false
+// if the message is not JSON-RPC 2.0, or a request without any method registered, or a response of no call pending,
+// the message is received as before
+func (w *SocketClient) dispatchRPC(msg *api.SockMessage) (req *rpcMessage, ok bool) {
+	if w == nil {
+		return nil, false
+	}
+	w.locker.RLock()
+	p := w.rpc
+	w.locker.RUnlock()
+	return p.dispatch(msg)
+}
+
+func (p *rpcPeer) dispatch(msg *api.SockMessage) (req *rpcMessage, ok bool) {
+	if p == nil {
+		return nil, false
+	}
+	data := bytes.TrimSpace(msg.Data)
+	if len(data) == 0 || data[0] != '{' || !bytes.Contains(data, []byte(`"jsonrpc"`)) {
+		return nil, false
+	}
+	var m rpcMessage
+	if err := json.Unmarshal(data, &m); err != nil || m.Version != rpcVersion {
+		return nil, false
+	}
+	if m.Method == "" {
+		return nil, p.resolve(&m)
+	}
+	if p.methods.empty() {
+		return nil, false
+	}
+	return &m, true
+}
+
+// rpcHandler is the innermost handler of server middlewares, it dispatches the RPC messages and passes the others to
+// the handler of server, so the middlewares (eg. authorization) see the RPC requests too
+type rpcHandler struct {
+	SocketHandler
+	server *SocketServer
+}
+
+func (h *rpcHandler) OnReceive(c *SocketClient, msg *api.SockMessage) {
+	req, ok := c.dispatchRPC(msg)
+	if !ok {
+		h.SocketHandler.OnReceive(c, msg)
+		return
+	}
+	if req != nil {
+		atomic.AddInt32(&h.server.inflight, 1)
+		go func() {
+			defer atomic.AddInt32(&h.server.inflight, -1)
+			c.serveRPC(req)
+		}()
+	}
+}
+
+// serveRPC call the method handler and reply result, no reply for a notification (request without id)
+func (w *SocketClient) serveRPC(m *rpcMessage) {
+	result, err := w.callRPC(m)
+	if len(m.ID) == 0 {
+		return
+	}
+	reply := &rpcMessage{
+		Version: rpcVersion,
+		ID:      m.ID,
+	}
+	if err == nil {
+		if reply.Result, err = json.Marshal(result); err != nil {
+			err = &RPCError{Code: RPCInternalError, Message: err.Error()}
+		}
+	}
+	if err != nil {
+		var re *RPCError
+		if !errors.As(err, &re) {
+			re = &RPCError{Code: RPCServerError, Message: err.Error()}
+		}
+		reply.Result, reply.Error = nil, re
+	}
+	data, err := json.Marshal(reply)
+	if err != nil {
+		log.Errorf("marshal response of [%s] error [%s]", m.Method, err.Error())
+		return
+	}
+	if _, err = w.SendMessage(types.MESSAGE_TYPE_TEXT, data); err != nil {
+		log.Warnf("reply [%s] to [%s] error [%s]", m.Method, w.GetRemoteAddr(), err.Error())
+	}
+}
+
+// callRPC call method handler, a panic is recovered as internal error
+func (w *SocketClient) callRPC(m *rpcMessage) (result interface{}, err error) {
+	fn := w.getRPC().methods.get(m.Method)
+	if fn == nil {
+		return nil, &RPCError{Code: RPCMethodNotFound, Message: fmt.Sprintf("method [%s] not found", m.Method)}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("rpc method [%s] panic [%v]", m.Method, r)
+			result, err = nil, &RPCError{Code: RPCInternalError, Message: fmt.Sprintf("method [%s] panic", m.Method)}
+		}
+	}()
+	return fn(w, &RPCRequest{
+		Method: m.Method,
+		Params: m.Params,
+	})
+}
+
+func (m *rpcMethods) add(method string, fn RPCHandler) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.handlers[method] = fn
+}
+
+func (m *rpcMethods) empty() bool {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	return len(m.handlers) == 0
+}
+
+func (m *rpcMethods) get(method string) RPCHandler {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	return m.handlers[method]
+}
+
+// register a call and returns the call id, an error returned if connection closed
+func (p *rpcPeer) register() (id string, ch chan *rpcMessage, err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.err != nil {
+		return "", nil, p.err
+	}
+	p.seq++
+	id = strconv.FormatUint(p.seq, 10)
+	ch = make(chan *rpcMessage, 1)
+	p.pending[id] = ch
+	return
+}
+
+func (p *rpcPeer) unregister(id string) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	delete(p.pending, id)
+}
+
+// resolve complete the call of response id, false if no call of the id pending (eg. timeout or not a response of Call)
+func (p *rpcPeer) resolve(m *rpcMessage) bool {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	ch, ok := p.pending[string(m.ID)]
+	if ok {
+		delete(p.pending, string(m.ID))
+		ch <- m
+	}
+	return ok
+}
+
+// close fail the calls in flight and stop client reader
+func (p *rpcPeer) close(err error) {
+	if p == nil {
+		return
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.err != nil {
+		return
+	}
+	if err == nil {
+		err = ErrRPCClosed
+	}
+	p.err = err
+	for id, ch := range p.pending {
+		delete(p.pending, id)
+		ch <- &rpcMessage{err: err}
+	}
+	if p.done != nil {
+		close(p.done)
+	}
+}
+
+// started returns true if client reader started
+func (p *rpcPeer) started() bool {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.inbox != nil
+}
+
+func (p *rpcPeer) getError() error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return p.err
+}
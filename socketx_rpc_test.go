@@ -0,0 +1,135 @@
+package socketx
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/civet148/socketx/api"
+)
+
+// the JSON-RPC messages are received as before by the side without methods registered or calls pending
+func TestRPCPassThrough(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	received := make(chan string, 1)
+	srv := NewServer(listen)
+	url, _ := listenServer(t, srv, &SocketHandlerFuncs{Receive: func(c *SocketClient, msg *api.SockMessage) {
+		received <- string(msg.Data)
+		_, _ = c.SendText(`{"jsonrpc":"2.0","id":999,"result":0}`)
+	}})
+	defer srv.Close()
+
+	c := NewClient()
+	if err := c.Connect(url); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := c.Call(ctx, "echo", nil, nil); err == nil {
+		t.Fatal("call a server without methods succeeded")
+	}
+	select {
+	case data := <-received:
+		if !strings.Contains(data, `"method":"echo"`) {
+			t.Fatalf("server received [%s]", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request not received by server OnReceive")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, err := c.RecvContext(ctx, -1)
+	if err != nil {
+		t.Fatalf("response of no call pending not received [%v]", err)
+	}
+	if !strings.Contains(string(msg.Data), `"id":999`) {
+		t.Fatalf("client received [%s]", msg.Data)
+	}
+}
+
+// the RPC requests pass through the middlewares of server, eg. authorization
+func TestRPCMiddleware(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	srv := NewServer(listen)
+	srv.Use(ReceiveMiddleware(func(c *SocketClient, msg *api.SockMessage, next ReceiveFunc) {
+		if string(msg.Data) == "login" {
+			c.Set("user", "test")
+			return
+		}
+		if _, ok := c.Get("user"); ok {
+			next(c, msg)
+		}
+	}))
+	srv.HandleRPC("echo", func(c *SocketClient, req *RPCRequest) (interface{}, error) {
+		return string(req.Params), nil
+	})
+	url, _ := listenServer(t, srv, &SocketHandlerFuncs{})
+	defer srv.Close()
+
+	c := NewClient()
+	if err := c.Connect(url); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := c.Call(ctx, "echo", 1, nil); !api.IsTimeout(err) {
+		t.Fatalf("call before login expect timeout, got %v", err)
+	}
+	if _, err := c.Send([]byte("login")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var resp string
+	if err := c.Call(ctx, "echo", 1, &resp); err != nil || resp != "1" {
+		t.Fatalf("call after login got [%s] error %v", resp, err)
+	}
+}
+
+// the client calls and receives again after connected again, and the messages not RPC are not dropped when the queue
+// of client reader is full
+func TestRPCConnectAgain(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	const count = 1500
+	srv := NewServer(listen)
+	srv.HandleRPC("flood", func(c *SocketClient, req *RPCRequest) (interface{}, error) {
+		for i := 0; i < count; i++ {
+			_, _ = c.SendText("hello")
+		}
+		return nil, nil
+	})
+	url, _ := listenServer(t, srv, &SocketHandlerFuncs{})
+	defer srv.Close()
+
+	c := NewClient()
+	for round := 0; round < 2; round++ {
+		if err := c.Connect(url); err != nil {
+			t.Fatal(err)
+		}
+		if round == 0 {
+			c.HandleRPC("ping", func(c *SocketClient, req *RPCRequest) (interface{}, error) {
+				return "pong", nil
+			}) //start client reader, restarted by Connect in next round
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		called := make(chan error, 1)
+		go func() {
+			called <- c.Call(ctx, "flood", nil, nil) //the response is read after the messages received
+		}()
+		for i := 0; i < count; i++ {
+			if _, err := c.RecvContext(ctx, -1); err != nil {
+				cancel()
+				t.Fatalf("round %d message %d not received [%v]", round, i, err)
+			}
+		}
+		if err := <-called; err != nil {
+			cancel()
+			t.Fatalf("round %d call error [%v]", round, err)
+		}
+		cancel()
+		_ = c.Close()
+	}
+}
@@ -1,31 +1,72 @@
 package socketx
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/civet148/gotools/parser"
 	"github.com/civet148/log"
 	"github.com/civet148/socketx/api"
 	"github.com/civet148/socketx/types"
+	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var ErrServerClosed = errors.New("socketx: server closed")
+
 type SocketHandler interface {
 	OnAccept(c *SocketClient)
 	OnReceive(c *SocketClient, msg *api.SockMessage)
 	OnClose(c *SocketClient)
 }
 
+// SocketAcceptHandler is an optional interface of SocketHandler, OnAccepting is called before OnAccept and the
+// connection is closed without OnAccept/OnClose if an error returned (eg. authorization failed), the client context
+// set by OnAccepting is available in the other callbacks
+type SocketAcceptHandler interface {
+	OnAccepting(c *SocketClient) error
+}
+
+// SocketShutdownHandler is an optional interface of SocketHandler, OnShutdown is called
+// for each connected client when the server starts shutting down (eg. send a goodbye message)
+type SocketShutdownHandler interface {
+	OnShutdown(c *SocketClient)
+}
+
 type SocketServer struct {
-	url       string                       //listen url
-	sock      api.Socket                   //server socket
-	handler   SocketHandler                //server callback handler
-	accepting chan api.Socket              //client connection accepted
-	receiving chan api.Socket              //client message received
-	quiting   chan api.Socket              //client connection closed
-	clients   map[api.Socket]*SocketClient //socket clients
-	locker    *sync.Mutex                  //locker mutex
-	done      chan bool                    //force close server socket
+	url        string                       //listen url
+	addr       string                       //bound address after listening
+	sock       api.Socket                   //server socket
+	handler    SocketHandler                //server callback handler
+	chain      SocketHandler                //server callback handler wrapped by middlewares
+	middleware []Middleware                 //middlewares of handler
+	accepting  chan api.Socket              //client connection accepted
+	receiving  chan api.Socket              //client message received
+	quiting    chan api.Socket              //client connection closed
+	clients    map[api.Socket]*SocketClient //socket clients
+	locker     *sync.Mutex                  //locker mutex
+	done       chan bool                    //closed when server shutdown
+	exit       chan bool                    //stop event loop
+	closing    bool                         //server is shutting down
+	once       sync.Once                    //shutdown once
+	acceptor   sync.WaitGroup               //accept goroutine
+	routines   sync.WaitGroup               //client read goroutines
+	events     sync.WaitGroup               //event loop goroutine
+	inflight   int32                        //OnReceive handlers in progress
+	heartbeat  *api.HeartbeatOption         //heartbeat option
+	session    bool                         //UDP session mode
+	groups     *groups                      //client groups
+	fanout     sync.WaitGroup               //client broadcast goroutines
+	bcastOpt   *api.BroadcastOption         //broadcast option
+	rpcMethods *rpcMethods                  //RPC method handlers
+	metrics    api.Metrics                  //metrics recorder, nil means disabled
+	rateLimit  *api.RateLimitOption         //rate limit option, nil means unlimited
+	limitIn    *rateLimiter                 //inbound limiter shared by all clients, nil means unlimited
+	limitOut   *rateLimiter                 //outbound limiter shared by all clients, nil means unlimited
+	admission  *admission                   //connection admission control
 }
 
 func init() {
@@ -37,50 +78,70 @@ func NewServer(url string, options ...api.SocketOption) *SocketServer {
 	var s api.Socket
 	s = createSocket(url, options...)
 
+	var limitIn, limitOut *rateLimiter
+	rateLimit := api.GetRateLimit(options...)
+	if rateLimit != nil {
+		limitIn, limitOut = newRateLimiter(rateLimit.ServerIn), newRateLimiter(rateLimit.ServerOut)
+	}
 	return &SocketServer{
-		url:       url,
-		locker:    &sync.Mutex{},
-		sock:      s,
-		done:      make(chan bool),
-		accepting: make(chan api.Socket, 1000),
-		quiting:   make(chan api.Socket, 1000),
-		clients:   make(map[api.Socket]*SocketClient, 0),
+		url:        url,
+		locker:     &sync.Mutex{},
+		sock:       s,
+		done:       make(chan bool),
+		exit:       make(chan bool),
+		accepting:  make(chan api.Socket, 1000),
+		quiting:    make(chan api.Socket, 1000),
+		clients:    make(map[api.Socket]*SocketClient, 0),
+		heartbeat:  api.GetHeartbeat(options...),
+		session:    api.GetUDPSession(options...) != nil,
+		groups:     newGroups(),
+		bcastOpt:   api.GetBroadcast(options...),
+		rpcMethods: newRPCMethods(),
+		metrics:    api.GetMetrics(options...),
+		rateLimit:  rateLimit,
+		limitIn:    limitIn,
+		limitOut:   limitOut,
+		admission:  newAdmission(api.GetAdmission(options...)),
 	}
 }
 
 // TCP       => 		tcp://127.0.0.1:6666
-// UDP       => 		udp://127.0.0.1:6667
+// UDP       => 		udp://127.0.0.1:6667 (set api.SocketOption.UDPSession to accept each remote address as a client, UDPFragment for messages larger than MTU)
 // WebSocket => 		ws://127.0.0.1:6668/ wss://127.0.0.1:6668/websocket?cert=cert.pem&key=key.pem
+// RUDP      => 		rudp://127.0.0.1:6669
+// UNIX      => 		unix:///tmp/unix.sock unix://@myservice (linux abstract name) unixgram:///tmp/unixgram.sock
 func (w *SocketServer) Listen(handler SocketHandler) (err error) {
+	w.lock()
 	w.handler = handler
+	w.chain = Chain(&rpcHandler{SocketHandler: handler, server: w}, w.middleware...) //RPC messages pass through middlewares
+	w.unlock()
+	if err = w.admission.err; err != nil {
+		log.Errorf(err.Error())
+		return
+	}
 	if err = w.sock.Listen(); err != nil {
 		log.Errorf(err.Error())
 		return
 	}
-	log.Infof("listen [%v] address [%v] ok", w.sock.GetSocketType(), w.sock.GetLocalAddr())
-	if w.sock.GetSocketType() != types.SocketType_UDP {
-		go func() {
-			//log.Tracef("start goroutine for channel event accepting/quiting")
-			for {
-				select {
-				case s := <-w.accepting: //client connection coming...
-					w.onAccept(s)
-				case s := <-w.quiting: //client connection closed
-					w.onClose(s)
-					//default: //disable default because of high CPU performance
-				}
-			}
-		}()
+	addr := w.sock.GetLocalAddr()
+	log.Infof("listen [%v] address [%v] ok", w.sock.GetSocketType(), addr)
 
-		//new go routine for accept new connections
-		go func() {
-			//log.Tracef("start goroutine for accept new connection")
-			for {
-				if s := w.sock.Accept(); s != nil { //socket accepting...
-					w.accepting <- s
-				}
-			}
-		}()
+	w.lock()
+	w.addr = addr
+	if w.closing {
+		w.unlock()
+		_ = w.sock.Close()
+		return ErrServerClosed
+	}
+	w.events.Add(1)
+	if !w.isPacket() {
+		w.acceptor.Add(1)
+	}
+	w.unlock()
+
+	go w.eventLoop()
+	if !w.isPacket() {
+		go w.acceptLoop()
 	} else {
 		w.onAccept(w.sock)
 	}
@@ -89,10 +150,85 @@ func (w *SocketServer) Listen(handler SocketHandler) (err error) {
 	return
 }
 
+// Close close server socket and all clients immediately without waiting for the handlers, it can be called by a handler
 func (w *SocketServer) Close() {
-	w.done <- true
-	w.sock.Close()
-	w.closeClientAll()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = w.Shutdown(ctx)
+}
+
+// Shutdown gracefully shuts down the server: stop accepting new connections, call OnShutdown of handler (if implemented)
+// for each client, wait for in-flight OnReceive handlers to finish and clients to disconnect until ctx expired, then
+// force close the rest clients. Shutdown returns after all internal goroutines exited, or ctx.Err() is returned after
+// ctx expired (the goroutines exit in background), eg. Shutdown called by a handler waits for itself until ctx expired.
+func (w *SocketServer) Shutdown(ctx context.Context) (err error) {
+	w.lock()
+	if w.closing {
+		w.unlock()
+		return ErrServerClosed
+	}
+	w.closing = true
+	w.unlock()
+
+	_ = w.sock.Close() //stop accepting
+
+	if h, ok := w.handler.(SocketShutdownHandler); ok {
+		for _, c := range w.getClientAll() {
+			h.OnShutdown(c)
+		}
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for !w.isIdle() {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			w.closeClientAll()
+		case <-ticker.C:
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	go w.stop()
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// stop wait for the internal goroutines exited after clients closed, it runs in background so that a handler (running
+// on a read goroutine or event loop) can shut down the server
+func (w *SocketServer) stop() {
+	w.acceptor.Wait()
+	w.routines.Wait()
+	close(w.exit)
+	w.events.Wait()
+	w.fanout.Wait()
+	w.once.Do(func() {
+		close(w.done)
+	})
+}
+
+// GetLocalAddr returns the bound address of server socket (eg. the port assigned for tcp://127.0.0.1:0), empty
+// before listening
+func (w *SocketServer) GetLocalAddr() string {
+	w.lock()
+	defer w.unlock()
+	return w.addr
+}
+
+// Handler returns the http.Handler of web socket upgrade endpoint (nil for other socket types), set
+// api.SocketOption.Detached and mount it on your own http server, connections are accepted after Listen called
+func (w *SocketServer) Handler() http.Handler {
+	if h, ok := w.sock.(http.Handler); ok {
+		return h
+	}
+	return nil
 }
 
 func (w *SocketServer) CloseClient(client *SocketClient) (err error) {
@@ -100,7 +236,31 @@ func (w *SocketServer) CloseClient(client *SocketClient) (err error) {
 }
 
 func (w *SocketServer) Send(client *SocketClient, data []byte, to ...string) (n int, err error) {
-	return w.sendSocket(client.sock, data, to...)
+	if err = client.limitOut(len(data)); err != nil {
+		return
+	}
+	n, err = w.sendSocket(client.sock, data, to...)
+	client.onSent(client.sock, n, err)
+	return
+}
+
+// SendText send a text message to client (web socket), same as Send for other socket types
+func (w *SocketServer) SendText(client *SocketClient, text string) (n int, err error) {
+	return w.SendMessage(client, types.MESSAGE_TYPE_TEXT, []byte(text))
+}
+
+// SendMessage send data with message type types.MESSAGE_TYPE_XXX to client, eg. echo by SendMessage(client, msg.MsgType, msg.Data)
+func (w *SocketServer) SendMessage(client *SocketClient, msgType int, data []byte) (n int, err error) {
+	if client == nil || client.sock == nil || len(data) == 0 {
+		err = fmt.Errorf("send socket is nil or data length is 0")
+		return
+	}
+	if err = client.limitOut(len(data)); err != nil {
+		return
+	}
+	n, err = sendMessage(client.sock, msgType, data)
+	client.onSent(client.sock, n, err)
+	return
 }
 
 func (w *SocketServer) GetClientCount() int {
@@ -115,7 +275,9 @@ func (w *SocketServer) closeSocket(s api.Socket) (err error) {
 	if s == nil {
 		return fmt.Errorf("close socket is nil")
 	}
-	w.removeClient(s)
+	if c := w.removeClient(s); c != nil {
+		w.releaseClient(c)
+	}
 	return s.Close()
 }
 
@@ -135,36 +297,145 @@ func (w *SocketServer) recvSocket(s api.Socket) (msg *api.SockMessage, err error
 	return s.Recv(-1)
 }
 
+func (w *SocketServer) eventLoop() {
+	defer w.events.Done()
+	for {
+		select {
+		case s := <-w.accepting: //client connection coming...
+			w.onAccept(s)
+		case s := <-w.quiting: //client connection closed
+			w.onClose(s)
+		case <-w.exit: //all client read goroutines exited
+			for {
+				select {
+				case s := <-w.accepting:
+					_ = s.Close()
+				case s := <-w.quiting:
+					w.onClose(s)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *SocketServer) acceptLoop() {
+	defer w.acceptor.Done()
+	for {
+		if s := w.sock.Accept(); s != nil { //socket accepting...
+			if !w.admit(s) {
+				continue //rejected by allow/deny lists or accept rate
+			}
+			if h, ok := s.(api.Handshaker); ok {
+				w.acceptor.Add(1)
+				go w.handshake(h, s)
+			} else {
+				w.accepting <- s
+			}
+		} else if w.isClosing() {
+			return
+		}
+	}
+}
+
+// handshake run handshake (eg. TLS) before accepting the connection
+func (w *SocketServer) handshake(h api.Handshaker, s api.Socket) {
+	defer w.acceptor.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), types.TLS_HANDSHAKE_TIMEOUT)
+	defer cancel()
+	if err := h.Handshake(ctx); err != nil {
+		_ = s.Close()
+		return
+	}
+	w.accepting <- s
+}
+
 func (w *SocketServer) onAccept(s api.Socket) {
+	if !w.isPacket() && !w.admitClient(s) {
+		return
+	}
 	c := w.addClient(s)
-	w.handler.OnAccept(c)
-	time.Sleep(100 * time.Millisecond)
+	if c == nil { //server is shutting down
+		_ = s.Close()
+		return
+	}
+	if h, ok := w.handler.(SocketAcceptHandler); ok {
+		if err := h.OnAccepting(c); err != nil {
+			w.removeClient(s)
+			w.freeClient(c) //counted as rejected only, not accepted or closed
+			w.reject(s, fmt.Sprintf("vetoed by OnAccepting [%s]", err.Error()))
+			w.routines.Done()
+			return
+		}
+	}
+	if !w.isPacket() {
+		c.onConnected(s)
+	}
+	w.chain.OnAccept(c)
+	if w.getClient(s) == nil { //closed by CloseClient in OnAccept
+		w.routines.Done()
+		return
+	}
 	go w.readSocket(s)
 }
 
 func (w *SocketServer) onClose(s api.Socket) {
-	w.handler.OnClose(w.removeClient(s))
+	_ = s.Close()
+	if c := w.removeClient(s); c != nil {
+		w.chain.OnClose(c)
+		w.releaseClient(c) //the groups of client are still available in OnClose
+	}
 }
 
 func (w *SocketServer) onReceive(s api.Socket, msg *api.SockMessage) {
+	atomic.AddInt32(&w.inflight, 1)
+	defer atomic.AddInt32(&w.inflight, -1)
 	c := w.getClient(s)
-	w.handler.OnReceive(c, msg)
+	t := time.Now()
+	w.chain.OnReceive(c, msg)
+	c.onHandled(s, t)
 }
 
 func (w *SocketServer) readSocket(s api.Socket) {
+	defer w.routines.Done()
+	hb := startHeartbeat(w.heartbeat, func() api.Socket { return s }, func(s api.Socket) {
+		_ = s.Close() //read error will be returned and then OnClose called
+	})
+	defer hb.stop()
+	c := w.getClient(s)
 	for {
 		msg, err := w.recvSocket(s)
+		c.onReceived(s, msg, err)
+		if isDropped(err) {
+			continue //message dropped, the socket is still usable
+		}
 		if err != nil {
 			w.quiting <- s
 			break
 		}
 		n := len(msg.Data)
-		if n > 0 {
+		if n > 0 && c.limitIn(n) {
 			w.onReceive(s, msg)
+			continue
+		}
+		for _, f := range msg.Files {
+			_ = f.Close() //message dropped by rate limits
 		}
 	}
 }
 
+// isPacket returns true if the server socket is a packet socket without connections (UDP not in session mode or UNIX datagram)
+func (w *SocketServer) isPacket() bool {
+	switch w.sock.GetSocketType() {
+	case types.SocketType_UDP:
+		return !w.session
+	case types.SocketType_UNIXGRAM:
+		return true
+	}
+	return false
+}
+
 func (w *SocketServer) lock() {
 	w.locker.Lock()
 }
@@ -173,22 +444,45 @@ func (w *SocketServer) unlock() {
 	w.locker.Unlock()
 }
 
+func (w *SocketServer) isClosing() bool {
+	w.lock()
+	defer w.unlock()
+	return w.closing
+}
+
+// isIdle returns true if no OnReceive handler in progress and no client connected
+func (w *SocketServer) isIdle() bool {
+	return atomic.LoadInt32(&w.inflight) == 0 && w.getClientCount() == 0
+}
+
+// closeClientAll close all client sockets, the client read goroutines will remove them and call OnClose
 func (w *SocketServer) closeClientAll() {
 	w.lock()
 	defer w.unlock()
-	for s, _ := range w.clients {
+	for s, c := range w.clients {
+		c.limits.stop() //the read goroutine may be delayed by rate limits
 		_ = s.Close()
-		delete(w.clients, s)
 	}
 }
 
+// addClient add a client and count its read goroutine, nil returned if server is shutting down
 func (w *SocketServer) addClient(s api.Socket) (client *SocketClient) {
-	client = &SocketClient{
-		sock: s,
-	}
 	w.lock()
 	defer w.unlock()
+	if w.closing {
+		return nil
+	}
+	client = &SocketClient{
+		sock:    s,
+		rpc:     newRPCPeer(w.rpcMethods, true),
+		metrics: w.metrics,
+		stats:   newClientStats(),
+		addrKey: w.admission.ipKey(remoteIP(s)),
+	}
+	client.limits = w.newClientLimits(client)
+	w.admission.addConn(client.addrKey)
 	w.clients[client.sock] = client
+	w.routines.Add(1)
 	return client
 }
 
@@ -197,6 +491,9 @@ func (w *SocketServer) removeClient(s api.Socket) (client *SocketClient) {
 	defer w.unlock()
 	client = w.clients[s]
 	delete(w.clients, s)
+	if client != nil {
+		w.admission.removeConn(client.addrKey)
+	}
 	return
 }
 
@@ -226,27 +523,53 @@ func (w *SocketServer) getClientAll() (clients []*SocketClient) {
 }
 
 func createSocket(url string, options ...api.SocketOption) (s api.Socket) {
-	ui := parser.ParseUrl(url)
+	ui := parseUrl(url)
 	if len(options) != 0 {
 		opt := options[0]
-		ui.Queries[types.WSS_TLS_CERT] = opt.CertFile
-		ui.Queries[types.WSS_TLS_KEY] = opt.KeyFile
+		if opt.CertFile != "" {
+			ui.Queries[types.WSS_TLS_CERT] = opt.CertFile
+		}
+		if opt.KeyFile != "" {
+			ui.Queries[types.WSS_TLS_KEY] = opt.KeyFile
+		}
 	}
 	switch ui.Scheme {
-	case types.URL_SCHEME_TCP, types.URL_SCHEME_TCP4, types.URL_SCHEME_TCP6:
-		s = api.NewSocketInstance(types.SocketType_TCP, ui)
+	case types.URL_SCHEME_TCP, types.URL_SCHEME_TCP4, types.URL_SCHEME_TCP6,
+		types.URL_SCHEME_TLS, types.URL_SCHEME_TLS4, types.URL_SCHEME_TLS6:
+		s = api.NewSocketInstance(types.SocketType_TCP, ui, options...)
 	case types.URL_SCHEME_WS, types.URL_SCHEME_WSS:
 		s = api.NewSocketInstance(types.SocketType_WEB, ui, options...)
 	case types.URL_SCHEME_UDP, types.URL_SCHEME_UDP4, types.URL_SCHEME_UDP6:
-		s = api.NewSocketInstance(types.SocketType_UDP, ui)
-	case types.URL_SCHEME_UNIX:
-		s = api.NewSocketInstance(types.SocketType_UNIX, ui)
+		s = api.NewSocketInstance(types.SocketType_UDP, ui, options...)
+	case types.URL_SCHEME_UNIX, types.URL_SCHEME_UNIX_TLS:
+		s = api.NewSocketInstance(types.SocketType_UNIX, ui, options...)
+	case types.URL_SCHEME_UNIXGRAM:
+		s = api.NewSocketInstance(types.SocketType_UNIXGRAM, ui, options...)
+	case types.URL_SCHEME_RUDP, types.URL_SCHEME_RUDP4, types.URL_SCHEME_RUDP6:
+		s = api.NewSocketInstance(types.SocketType_RUDP, ui, options...)
 	default:
 		{
 			url = types.URL_SCHEME_TCP + parser.URL_SCHEME_SEP + url
 			ui = parser.ParseUrl(url)
-			s = api.NewSocketInstance(types.SocketType_TCP, ui) //default 'tcp'
+			s = api.NewSocketInstance(types.SocketType_TCP, ui, options...) //default 'tcp'
 		}
 	}
 	return
 }
+
+// parseUrl parse url, the linux abstract name of UNIX socket (eg. unix://@myservice) is kept in path
+func parseUrl(url string) *parser.UrlInfo {
+	nIndex := strings.Index(url, parser.URL_SCHEME_SEP)
+	if nIndex > 0 {
+		scheme, addr := url[:nIndex], url[nIndex+len(parser.URL_SCHEME_SEP):]
+		switch scheme {
+		case types.URL_SCHEME_UNIX, types.URL_SCHEME_UNIX_TLS, types.URL_SCHEME_UNIXGRAM:
+			if strings.HasPrefix(addr, "@") { //'@' is parsed as user info separator
+				ui := parser.ParseUrl(scheme + parser.URL_SCHEME_SEP + "/" + addr[1:])
+				ui.Path = "@" + strings.TrimPrefix(ui.Path, "/")
+				return ui
+			}
+		}
+	}
+	return parser.ParseUrl(url)
+}
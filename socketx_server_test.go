@@ -0,0 +1,144 @@
+package socketx
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/civet148/socketx/api"
+)
+
+// listenServer runs Listen of srv in background, it returns the url of the bound address (the port assigned for
+// 127.0.0.1:0) and a channel closed after Listen returned
+func listenServer(t *testing.T, srv *SocketServer, handler SocketHandler) (url string, stopped chan struct{}) {
+	stopped = make(chan struct{})
+	go func() {
+		_ = srv.Listen(handler)
+		close(stopped)
+	}()
+	for deadline := time.Now().Add(time.Second); srv.GetLocalAddr() == ""; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("server [%s] not listening", srv.url)
+		}
+	}
+	return strings.Replace(srv.url, "127.0.0.1:0", srv.GetLocalAddr(), 1), stopped
+}
+
+// a handler calling Close on a read goroutine (OnReceive) or the event loop (OnClose) must not deadlock
+func TestCloseInHandler(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	for _, event := range []string{"OnReceive", "OnClose"} {
+		var srv *SocketServer
+		h := &SocketHandlerFuncs{}
+		if event == "OnReceive" {
+			h.Receive = func(c *SocketClient, msg *api.SockMessage) { srv.Close() }
+		} else {
+			h.Close = func(c *SocketClient) { srv.Close() }
+		}
+		srv = NewServer(listen)
+		url, stopped := listenServer(t, srv, h)
+
+		c := NewClient()
+		if err := c.Connect(url); err != nil {
+			t.Fatal(err)
+		}
+		if event == "OnReceive" {
+			_, _ = c.Send([]byte("close"))
+		} else {
+			_ = c.Close()
+		}
+		select {
+		case <-stopped:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("server closed by %s deadlocked", event)
+		}
+		_ = c.Close()
+	}
+}
+
+type shutdownHandler struct {
+	SocketHandlerFuncs
+	shutdown func(c *SocketClient)
+}
+
+func (h *shutdownHandler) OnShutdown(c *SocketClient) {
+	h.shutdown(c)
+}
+
+// Shutdown waits for the in-flight handlers and the clients disconnected after OnShutdown, the clients still connected
+// are closed when the context done, and the goroutines of server exit after Shutdown returned
+func TestShutdown(t *testing.T) {
+	const listen = "tcp://127.0.0.1:0?framer=len4"
+	for _, leave := range []bool{true, false} {
+		baseline := runtime.NumGoroutine()
+		var handled int32
+		srv := NewServer(listen)
+		h := &shutdownHandler{shutdown: func(c *SocketClient) {
+			_, _ = srv.Send(c, []byte("bye"))
+		}}
+		h.Receive = func(c *SocketClient, msg *api.SockMessage) {
+			time.Sleep(300 * time.Millisecond)
+			atomic.StoreInt32(&handled, 1)
+		}
+		url, stopped := listenServer(t, srv, h)
+
+		c := NewClient()
+		if err := c.Connect(url); err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				msg, err := c.Recv(-1)
+				if err != nil {
+					return
+				}
+				if leave && string(msg.Data) == "bye" {
+					_ = c.Close()
+				}
+			}
+		}()
+		if _, err := c.Send([]byte("work")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		start := time.Now()
+		err := srv.Shutdown(ctx)
+		cancel()
+		if leave {
+			if err != nil {
+				t.Fatalf("shutdown returns [%v] after client left", err)
+			}
+			if atomic.LoadInt32(&handled) == 0 {
+				t.Fatal("shutdown returns before the in-flight handler finished")
+			}
+		} else {
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("shutdown returns [%v] with client connected, want deadline exceeded", err)
+			}
+			if d := time.Since(start); d < 900*time.Millisecond {
+				t.Fatalf("shutdown returns after %v before the context done", d)
+			}
+		}
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("listen not returned after shutdown")
+		}
+		_ = c.Close()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > baseline {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Fatalf("%d goroutines left after shutdown, baseline %d\n%s", runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package socketx
+
+import (
+	"github.com/civet148/socketx/api"
+	"sync"
+	"time"
+)
+
+// ClientStats statistics snapshot of a client connection, the counters are accumulated across reconnections
+type ClientStats struct {
+	LocalAddr    string        //local address
+	RemoteAddr   string        //remote address
+	ConnectedAt  time.Time     //connection accepted or (re)connected time
+	LastActivity time.Time     //last message sent or received time (heartbeats not included), zero if no message
+	BytesIn      int64         //bytes received
+	BytesOut     int64         //bytes sent
+	MsgsIn       int64         //messages received
+	MsgsOut      int64         //messages sent
+	SendErrors   int64         //send errors
+	RTT          time.Duration //last heartbeat round trip time, 0 if heartbeat disabled or no pong received
+}
+
+// ServerStats statistics aggregated across the clients connected and the connections rejected
+type ServerStats struct {
+	Clients    int   //clients connected
+	BytesIn    int64 //bytes received
+	BytesOut   int64 //bytes sent
+	MsgsIn     int64 //messages received
+	MsgsOut    int64 //messages sent
+	SendErrors int64 //send errors
+	Rejected   int64 //connections rejected by admission control
+}
+
+// clientStats statistics of a client, nil-safe
+type clientStats struct {
+	locker       sync.Mutex
+	connectedAt  time.Time
+	lastActivity time.Time
+	bytesIn      int64
+	bytesOut     int64
+	msgsIn       int64
+	msgsOut      int64
+	sendErrors   int64
+}
+
+func newClientStats() *clientStats {
+	return &clientStats{
+		connectedAt: time.Now(),
+	}
+}
+
+// Stats returns statistics snapshot of the client, eg. find idle or heavy clients by the snapshots of
+// SocketServer.GetClientAll
+func (w *SocketClient) Stats() (stats ClientStats) {
+	if s := w.getSocket(); s != nil {
+		stats.LocalAddr = s.GetLocalAddr()
+		stats.RemoteAddr = s.GetRemoteAddr()
+		if p, ok := s.(api.Pinger); ok {
+			_, stats.RTT = p.GetPong()
+		}
+	}
+	if st := w.stats; st != nil {
+		st.locker.Lock()
+		defer st.locker.Unlock()
+		stats.ConnectedAt = st.connectedAt
+		stats.LastActivity = st.lastActivity
+		stats.BytesIn = st.bytesIn
+		stats.BytesOut = st.bytesOut
+		stats.MsgsIn = st.msgsIn
+		stats.MsgsOut = st.msgsOut
+		stats.SendErrors = st.sendErrors
+	}
+	return
+}
+
+// Stats returns statistics aggregated across the clients of GetClientAll
+func (w *SocketServer) Stats() (stats ServerStats) {
+	for _, c := range w.GetClientAll() {
+		cs := c.Stats()
+		stats.Clients++
+		stats.BytesIn += cs.BytesIn
+		stats.BytesOut += cs.BytesOut
+		stats.MsgsIn += cs.MsgsIn
+		stats.MsgsOut += cs.MsgsOut
+		stats.SendErrors += cs.SendErrors
+	}
+	stats.Rejected = w.GetRejectedCount()
+	return
+}
+
+func (s *clientStats) connected() {
+	if s == nil {
+		return
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.connectedAt = time.Now()
+}
+
+func (s *clientStats) sent(n int, err error) {
+	if s == nil {
+		return
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if err != nil {
+		s.sendErrors++
+		return
+	}
+	s.bytesOut += int64(n)
+	s.msgsOut++
+	s.lastActivity = time.Now()
+}
+
+func (s *clientStats) received(n int) {
+	if s == nil {
+		return
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.bytesIn += int64(n)
+	s.msgsIn++
+	s.lastActivity = time.Now()
+}
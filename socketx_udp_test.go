@@ -0,0 +1,49 @@
+package socketx
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/civet148/socketx/api"
+)
+
+// a malformed fragment must not close the UDP server socket
+func TestUDPServerBadFragment(t *testing.T) {
+	const listen = "udp://127.0.0.1:0"
+	opt := api.SocketOption{UDPFragment: &api.UDPFragmentOption{}}
+	received := make(chan []byte, 1)
+	srv := NewServer(listen, opt)
+	url, _ := listenServer(t, srv, &SocketHandlerFuncs{Receive: func(c *SocketClient, msg *api.SockMessage) {
+		received <- msg.Data
+	}})
+	defer srv.Close()
+
+	conn, err := net.Dial("udp", srv.GetLocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	//"XF" + id + index=5 + count=0
+	if _, err = conn.Write([]byte{'X', 'F', 0, 0, 0, 1, 0, 5, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	c := NewClient()
+	if err = c.Listen("udp://127.0.0.1:0", opt); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Send([]byte("hello"), url); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		if string(data) != "hello" {
+			t.Fatalf("receive [%s]", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server stopped receiving after a bad fragment")
+	}
+}
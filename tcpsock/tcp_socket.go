@@ -1,6 +1,10 @@
 package tcpsock
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/civet148/gotools/parser"
@@ -9,6 +13,7 @@ import (
 	"github.com/civet148/socketx/types"
 	"net"
 	"sync"
+	"time"
 )
 
 type socket struct {
@@ -16,7 +21,20 @@ type socket struct {
 	conn     net.Conn
 	listener net.Listener
 	closed   bool
+	cLocker  sync.Mutex //close locker
 	locker   sync.RWMutex
+	framer   api.Framer           //message framer, nil means raw stream
+	reader   *bufio.Reader        //buffered reader for framer
+	nread    int64                //bytes read from connection by framer reader
+	partial  bool                 //the last frame failed after read partially, the stream is out of sync
+	wpartial bool                 //the last frame failed after written partially, the stream is out of sync
+	err      error                //socket option error
+	hbOpt    *api.HeartbeatOption //heartbeat option, nil means disabled
+	hbLocker sync.Mutex           //heartbeat locker
+	pingAt   time.Time            //last ping sent time
+	pongAt   time.Time            //last pong received time
+	rtt      time.Duration        //last round trip time
+	options  []api.SocketOption   //socket options
 }
 
 func init() {
@@ -24,19 +42,38 @@ func init() {
 }
 
 func NewSocket(ui *parser.UrlInfo, options ...api.SocketOption) api.Socket {
-
+	framer, err := api.GetFramer(ui, options...)
+	if err != nil {
+		log.Errorf("get framer error [%s]", err.Error())
+	}
 	return &socket{
-		ui: ui,
+		ui:      ui,
+		framer:  framer,
+		err:     err,
+		hbOpt:   api.GetHeartbeat(options...),
+		options: options,
 	}
 }
 
 func (s *socket) Listen() (err error) {
+	if s.err != nil {
+		return s.err
+	}
 	var network = s.getNetwork()
 	strAddr := s.ui.GetHost()
-	s.listener, err = net.Listen(network, strAddr)
+	var lc = net.ListenConfig{KeepAlive: s.getKeepAlive()}
+	s.listener, err = lc.Listen(context.Background(), network, strAddr)
 	if err != nil {
 		return log.Errorf("listen tcp address [%s] error [%s]", strAddr, err.Error())
 	}
+	if s.isTLS() {
+		var config *tls.Config
+		if config, err = api.NewTLSConfig(s.ui, true, s.options...); err != nil {
+			_ = s.listener.Close()
+			return log.Errorf("listen tls address [%s] error [%s]", strAddr, err.Error())
+		}
+		s.listener = tls.NewListener(s.listener, config)
+	}
 	return
 }
 
@@ -46,22 +83,65 @@ func (s *socket) Accept() api.Socket {
 		return nil
 	}
 	return &socket{
-		conn: conn,
+		conn:    conn,
+		ui:      s.ui,
+		framer:  s.framer,
+		hbOpt:   s.hbOpt,
+		options: s.options,
 	}
 }
 
 func (s *socket) Connect() (err error) {
+	return s.ConnectContext(context.Background())
+}
+
+func (s *socket) ConnectContext(ctx context.Context) (err error) {
+	if s.err != nil {
+		return s.err
+	}
 	var network = s.getNetwork()
 	addr := s.ui.GetHost()
-	var tcpAddr *net.TCPAddr
-	tcpAddr, err = net.ResolveTCPAddr(network, addr)
+	var config *tls.Config
+	if s.isTLS() {
+		if config, err = api.NewTLSConfig(s.ui, false, s.options...); err != nil {
+			return log.Errorf("dial tls to [%s] error [%s]", addr, err.Error())
+		}
+	}
+	var dialer = net.Dialer{KeepAlive: s.getKeepAlive()}
+	s.conn, err = dialer.DialContext(ctx, network, addr)
 	if err != nil {
-		return log.Errorf("resolve tcp address [%s] failed, error [%s]", addr, err)
+		if ctx.Err() != nil {
+			log.Errorf("dial [%s] to [%s] error [%s]", network, addr, err.Error())
+			return api.NewTimeoutError("connect", ctx.Err())
+		}
+		return log.Errorf("dial [%s] to [%s] error [%s]", network, addr, err.Error())
 	}
+	if config != nil {
+		s.conn = tls.Client(s.conn, config)
+		if err = s.Handshake(ctx); err != nil {
+			_ = s.conn.Close()
+			return err
+		}
+	}
+	return
+}
 
-	s.conn, err = net.DialTCP(network, nil, tcpAddr)
-	if err != nil {
-		return log.Errorf("dial tcp to [%s] failed", addr)
+// Handshake run TLS handshake, do nothing if not a TLS connection
+func (s *socket) Handshake(ctx context.Context) (err error) {
+	tc, ok := s.conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	if err = api.RunContext(ctx, "handshake", tc.SetDeadline, tc.Handshake); err != nil {
+		return log.Errorf("tls handshake with [%s] error [%s]", s.GetRemoteAddr(), err.Error())
+	}
+	return
+}
+
+func (s *socket) GetTLSState() (state tls.ConnectionState, ok bool) {
+	var tc *tls.Conn
+	if tc, ok = s.conn.(*tls.Conn); ok {
+		state = tc.ConnectionState()
 	}
 	return
 }
@@ -69,7 +149,26 @@ func (s *socket) Connect() (err error) {
 func (s *socket) Send(data []byte, to ...string) (n int, err error) {
 	s.locker.Lock()
 	defer s.locker.Unlock()
-	return s.conn.Write(data)
+	return s.send(data)
+}
+
+// SendContext send with context, NOTE: the connection is closed if the context expired in the middle of a frame (or
+// a TLS write timed out), the peer can not tell the rest of the frame from the next one
+func (s *socket) SendContext(ctx context.Context, data []byte, to ...string) (n int, err error) {
+	if s.conn == nil {
+		return 0, fmt.Errorf("socket is nil")
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	err = api.RunContext(ctx, "send", s.conn.SetWriteDeadline, func() (e error) {
+		n, e = s.send(data)
+		return
+	})
+	if err != nil && (s.wpartial || api.IsTimeout(err) && s.isTLS()) {
+		log.Warnf("write a partial frame to %s error [%s], connection closed", s.GetRemoteAddr(), err.Error())
+		_ = s.Close()
+	}
+	return
 }
 
 func (s *socket) SendJson(v interface{}, to ...string) (n int, err error) {
@@ -81,8 +180,28 @@ func (s *socket) SendJson(v interface{}, to ...string) (n int, err error) {
 	return s.Send(data, to...)
 }
 
+// RecvContext receive with context, NOTE: the connection with a framer is closed if the context expired in the middle
+// of a frame, the rest of the frame can not be told from the next one
+func (s *socket) RecvContext(ctx context.Context, length int) (msg *api.SockMessage, err error) {
+	if s.conn == nil {
+		return nil, fmt.Errorf("socket is nil")
+	}
+	err = api.RunContext(ctx, "recv", s.conn.SetReadDeadline, func() (e error) {
+		msg, e = s.Recv(length)
+		return
+	})
+	if err != nil && s.partial {
+		log.Warnf("read a partial frame from %s error [%s], connection closed", s.GetRemoteAddr(), err.Error())
+		_ = s.Close()
+	}
+	return
+}
+
 // length <= 0, default PACK_FRAGMENT_MAX=1500 bytes
 func (s *socket) Recv(length int) (msg *api.SockMessage, err error) {
+	if s.framer != nil {
+		return s.recvFrame()
+	}
 
 	var once bool
 	var recv, left int
@@ -121,17 +240,33 @@ func (s *socket) Recv(length int) (msg *api.SockMessage, err error) {
 }
 
 func (s *socket) Close() (err error) {
-	if s.closed {
+	if s.listener == nil && s.conn == nil {
+		return fmt.Errorf("socket is nil")
+	}
+	if !s.setClosed() {
 		return fmt.Errorf("socket already closed")
 	}
-	if s.conn == nil {
-		return fmt.Errorf("socket is nil")
+	if s.listener != nil {
+		return s.listener.Close()
 	}
-	s.closed = true
 	return s.conn.Close()
 }
 
+// setClosed mark the socket closed, false returned if closed already (closed by server and handler concurrently)
+func (s *socket) setClosed() bool {
+	s.cLocker.Lock()
+	defer s.cLocker.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closed = true
+	return true
+}
+
 func (s *socket) GetLocalAddr() string {
+	if s.listener != nil {
+		return s.listener.Addr().String() //bound address of listener, eg. tcp://127.0.0.1:0
+	}
 	if s.conn == nil {
 		return s.ui.GetHost()
 	}
@@ -158,7 +293,15 @@ func (s *socket) getNetwork() string {
 
 func (s *socket) isTcp6() (ok bool) {
 	scheme := s.ui.GetScheme()
-	if scheme == types.URL_SCHEME_TCP6 {
+	if scheme == types.URL_SCHEME_TCP6 || scheme == types.URL_SCHEME_TLS6 {
+		return true
+	}
+	return
+}
+
+func (s *socket) isTLS() (ok bool) {
+	switch s.ui.GetScheme() {
+	case types.URL_SCHEME_TLS, types.URL_SCHEME_TLS4, types.URL_SCHEME_TLS6:
 		return true
 	}
 	return
@@ -167,3 +310,116 @@ func (s *socket) isTcp6() (ok bool) {
 func (s *socket) makeBuffer(length int) []byte {
 	return make([]byte, length)
 }
+
+func (s *socket) send(data []byte) (n int, err error) {
+	if s.framer == nil {
+		return s.conn.Write(data)
+	}
+	var frame []byte
+	if frame, err = s.framer.Encode(data); err != nil {
+		return 0, log.Errorf("encode message with framer [%s] error [%s]", s.framer.Name(), err.Error())
+	}
+	var written int
+	if written, err = s.conn.Write(frame); err != nil {
+		s.wpartial = written > 0
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Ping send an application level ping message, a framer is required
+func (s *socket) Ping() (err error) {
+	if s.hbOpt == nil || s.framer == nil {
+		return api.ErrHeartbeatNotSupported //heartbeat requires heartbeat option and framer
+	}
+	s.hbLocker.Lock()
+	s.pingAt = time.Now()
+	s.hbLocker.Unlock()
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	_, err = s.send(s.hbOpt.GetPingData())
+	return
+}
+
+func (s *socket) GetPong() (last time.Time, rtt time.Duration) {
+	s.hbLocker.Lock()
+	defer s.hbLocker.Unlock()
+	return s.pongAt, s.rtt
+}
+
+// onHeartbeat reply pong for ping message and record pong time, true returned if data is a heartbeat message
+func (s *socket) onHeartbeat(data []byte) bool {
+	if s.hbOpt == nil {
+		return false
+	}
+	if bytes.Equal(data, s.hbOpt.GetPingData()) {
+		s.locker.Lock()
+		defer s.locker.Unlock()
+		if _, err := s.send(s.hbOpt.GetPongData()); err != nil {
+			log.Warnf("reply pong to [%s] error [%s]", s.GetRemoteAddr(), err.Error())
+		}
+		return true
+	}
+	if bytes.Equal(data, s.hbOpt.GetPongData()) {
+		s.hbLocker.Lock()
+		defer s.hbLocker.Unlock()
+		s.pongAt = time.Now()
+		s.rtt = s.pongAt.Sub(s.pingAt)
+		return true
+	}
+	return false
+}
+
+// recvFrame read exactly one message by framer, the length parameter of Recv is ignored
+func (s *socket) recvFrame() (msg *api.SockMessage, err error) {
+	var data []byte
+	r := s.getReader()
+	for {
+		start := s.consumed()
+		if data, err = s.framer.Decode(r); err != nil {
+			s.partial = s.consumed() != start
+			break
+		}
+		if !s.onHeartbeat(data) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, log.Errorf("read data from %s error [%v]", s.GetRemoteAddr(), err.Error())
+	}
+	return &api.SockMessage{
+		Sock: s,
+		Data: data,
+		From: s.conn.RemoteAddr().String(),
+	}, nil
+}
+
+func (s *socket) getKeepAlive() time.Duration {
+	if s.hbOpt == nil {
+		return 0
+	}
+	return s.hbOpt.KeepAlive
+}
+
+func (s *socket) getReader() *bufio.Reader {
+	if s.reader == nil {
+		s.reader = bufio.NewReader(readFunc(func(p []byte) (n int, err error) {
+			n, err = s.conn.Read(p)
+			s.nread += int64(n)
+			return
+		}))
+	}
+	return s.reader
+}
+
+// consumed returns the bytes taken from framer reader
+func (s *socket) consumed() int64 {
+	return s.nread - int64(s.reader.Buffered())
+}
+
+// readFunc adapts a read function to io.Reader
+type readFunc func(p []byte) (n int, err error)
+
+func (f readFunc) Read(p []byte) (n int, err error) {
+	return f(p)
+}
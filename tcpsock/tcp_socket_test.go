@@ -0,0 +1,87 @@
+package tcpsock
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/civet148/gotools/parser"
+	"github.com/civet148/socketx/api"
+)
+
+// the connection must be closed if RecvContext timed out in the middle of a frame, instead of decoding the rest of the
+// frame as a new one
+func TestRecvContextPartialFrame(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	rest := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte{0, 0, 0, 6, 'h', 'i'})
+		<-rest
+		//the rest of the frame looks like an empty frame
+		_, _ = conn.Write([]byte{0, 0, 0, 0, 0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'})
+		time.Sleep(time.Second)
+	}()
+
+	s := NewSocket(parser.ParseUrl("tcp://" + l.Addr().String() + "?framer=len4"))
+	if err = s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = s.RecvContext(ctx, -1); !api.IsTimeout(err) {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+	close(rest)
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	msg, err := s.RecvContext(ctx, -1)
+	if err == nil || api.IsTimeout(err) {
+		t.Fatalf("expect connection closed, got message %q error %v", msg.Data, err)
+	}
+}
+
+// the connection must be closed if SendContext timed out in the middle of a frame, instead of writing the next frame
+// after the partial one
+func TestSendContextPartialFrame(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(time.Second) //never read
+	}()
+
+	s := NewSocket(parser.ParseUrl("tcp://" + l.Addr().String() + "?framer=len4"))
+	if err = s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	for err == nil { //the frame timed out is written partially when the socket buffer is full
+		_, err = s.SendContext(ctx, make([]byte, 512*1024))
+	}
+	if !api.IsTimeout(err) {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+	if _, err = s.Send([]byte("hello")); err == nil {
+		t.Fatal("expect connection closed, the next frame sent after a partial one")
+	}
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 const (
 	URL_SCHEME_TCP  = "tcp"
 	URL_SCHEME_TCP4 = "tcp4"
@@ -10,6 +12,16 @@ const (
 	URL_SCHEME_WS   = "ws"
 	URL_SCHEME_WSS  = "wss"
 	URL_SCHEME_UNIX = "unix"
+	URL_SCHEME_TLS  = "tls"
+	URL_SCHEME_TLS4 = "tls4"
+	URL_SCHEME_TLS6 = "tls6"
+
+	URL_SCHEME_UNIX_TLS = "unix+tls"
+	URL_SCHEME_UNIXGRAM = "unixgram" // UNIX datagram socket
+
+	URL_SCHEME_RUDP  = "rudp"  // reliable UDP
+	URL_SCHEME_RUDP4 = "rudp4" // reliable UDP over IPv4
+	URL_SCHEME_RUDP6 = "rudp6" // reliable UDP over IPv6
 )
 
 const (
@@ -25,20 +37,74 @@ const (
 	NETWORK_UDPv4 = "udp4"
 	NETWORK_UDPv6 = "udp6"
 	NETWORK_UNIX  = "unix"
+
+	NETWORK_UNIXGRAM = "unixgram"
 )
 
 const (
 	WSS_TLS_CERT = "cert"
 	WSS_TLS_KEY  = "key"
+	TLS_CA       = "ca" //CA bundle file to verify peer certificate, eg. tls://127.0.0.1:6666?ca=ca.pem
+)
+
+const (
+	TLS_HANDSHAKE_TIMEOUT = 10 * time.Second // server side TLS handshake timeout
+)
+
+const (
+	URL_QUERY_FRAMER      = "framer" // message framer name for TCP/UNIX stream, eg. tcp://127.0.0.1:6666?framer=len4
+	URL_QUERY_FRAMER_SIZE = "size"   // message size for fixed framer, eg. tcp://127.0.0.1:6666?framer=fixed&size=64
+)
+
+const (
+	FRAMER_LEN2    = "len2"    // 2 bytes big endian length prefix
+	FRAMER_LEN4    = "len4"    // 4 bytes big endian length prefix
+	FRAMER_LEN8    = "len8"    // 8 bytes big endian length prefix
+	FRAMER_LEN2_LE = "len2le"  // 2 bytes little endian length prefix
+	FRAMER_LEN4_LE = "len4le"  // 4 bytes little endian length prefix
+	FRAMER_LEN8_LE = "len8le"  // 8 bytes little endian length prefix
+	FRAMER_UVARINT = "uvarint" // unsigned varint length prefix
+	FRAMER_LF      = "lf"      // '\n' delimiter
+	FRAMER_CRLF    = "crlf"    // '\r\n' delimiter
+	FRAMER_NUL     = "nul"     // '\0' delimiter
+	FRAMER_FIXED   = "fixed"   // fixed size message
+)
+
+const (
+	URL_QUERY_IFACE = "iface" // UDP multicast interface name, eg. udp://239.1.2.3:9999?iface=eth0
+	URL_QUERY_TTL   = "ttl"   // UDP multicast TTL (IPv4) or hop limit (IPv6), eg. udp://239.1.2.3:9999?ttl=2
+	URL_QUERY_LOOP  = "loop"  // UDP multicast loopback, true by default, eg. udp://239.1.2.3:9999?loop=false
+)
+
+const (
+	URL_QUERY_LOSS    = "loss"    // RUDP test harness: drop outgoing packets with probability, eg. rudp://127.0.0.1:9999?loss=0.2
+	URL_QUERY_REORDER = "reorder" // RUDP test harness: delay outgoing packets with probability to reorder them, eg. reorder=0.1
+	URL_QUERY_DELAY   = "delay"   // RUDP test harness: delay of reordered packets, default 20ms, eg. delay=50ms
+)
+
+const (
+	WS_CONTROL_TIMEOUT = 5 * time.Second // web socket control frame write timeout
+)
+
+const (
+	HEARTBEAT_PING = "socketx:ping" // default application level ping message
+	HEARTBEAT_PONG = "socketx:pong" // default application level pong message
+)
+
+const (
+	MESSAGE_TYPE_TEXT   = 1 // web socket text message (UTF-8)
+	MESSAGE_TYPE_BINARY = 2 // web socket binary message
 )
 
 type SocketType int
 
 const (
-	SocketType_TCP  SocketType = 1
-	SocketType_WEB  SocketType = 2
-	SocketType_UDP  SocketType = 3
-	SocketType_UNIX SocketType = 4
+	SocketType_TCP      SocketType = 1
+	SocketType_WEB      SocketType = 2
+	SocketType_UDP      SocketType = 3
+	SocketType_UNIX     SocketType = 4
+	SocketType_RUDP     SocketType = 5
+	SocketType_UNIXGRAM SocketType = 6
 )
 
 func (s SocketType) GoString() string {
@@ -55,6 +121,30 @@ func (s SocketType) String() string {
 		return "UDP"
 	case SocketType_UNIX:
 		return "UNIX"
+	case SocketType_RUDP:
+		return "RUDP"
+	case SocketType_UNIXGRAM:
+		return "UNIXGRAM"
 	}
 	return "SocketType<Unknown>"
 }
+
+type RateLimitPolicy int
+
+const (
+	RateLimitPolicy_Delay      RateLimitPolicy = 0 // wait for tokens: inbound reading paused, outbound sending blocked
+	RateLimitPolicy_Drop       RateLimitPolicy = 1 // drop the inbound message, outbound message is not sent (error returned)
+	RateLimitPolicy_Disconnect RateLimitPolicy = 2 // close the client
+)
+
+func (p RateLimitPolicy) String() string {
+	switch p {
+	case RateLimitPolicy_Delay:
+		return "Delay"
+	case RateLimitPolicy_Drop:
+		return "Drop"
+	case RateLimitPolicy_Disconnect:
+		return "Disconnect"
+	}
+	return "RateLimitPolicy<Unknown>"
+}
@@ -0,0 +1,165 @@
+package udpsock
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/civet148/socketx/api"
+	"sync"
+	"time"
+)
+
+const (
+	fragmentMagic  = 0x5846 // "XF"
+	fragmentHeader = 10     // magic(2) message id(4) index(2) count(2)
+	fragmentMax    = 0xFFFF // max fragments of a message
+	fragmentCost   = 24     // memory cost of each fragment slot (slice header) counted against max memory
+)
+
+type fragments struct {
+	parts    [][]byte  //fragments by index
+	received int       //fragments received
+	size     int       //bytes received
+	cost     int       //memory of fragment slots
+	deadline time.Time //drop incomplete message after deadline
+}
+
+// reassembler reassemble fragments to messages with timeout and memory cap
+type reassembler struct {
+	opt      *api.UDPFragmentOption
+	locker   sync.Mutex
+	messages map[string]*fragments //incomplete messages by remote address and message id
+	bytes    int                   //total bytes of incomplete messages
+	purgeAt  time.Time             //last purge time
+}
+
+func newReassembler(opt *api.UDPFragmentOption) *reassembler {
+	return &reassembler{
+		opt:      opt,
+		messages: make(map[string]*fragments),
+	}
+}
+
+// fragment split message into datagrams with fragment header
+func fragment(opt *api.UDPFragmentOption, id uint32, data []byte) (datagrams [][]byte, err error) {
+	if len(data) > opt.GetMaxMessage() {
+		return nil, fmt.Errorf("%w: message length [%d] exceeds max message [%d]", api.ErrMessageTooLarge, len(data), opt.GetMaxMessage())
+	}
+	size := opt.GetMTU() - fragmentHeader
+	if size <= 0 {
+		return nil, fmt.Errorf("MTU [%d] must be larger than fragment header [%d]", opt.GetMTU(), fragmentHeader)
+	}
+	count := (len(data) + size - 1) / size
+	if count == 0 {
+		count = 1
+	}
+	if count > fragmentMax {
+		return nil, fmt.Errorf("%w: message length [%d] exceeds %d fragments", api.ErrMessageTooLarge, len(data), fragmentMax)
+	}
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[i*size : end]
+		b := make([]byte, fragmentHeader+len(chunk))
+		binary.BigEndian.PutUint16(b[0:], fragmentMagic)
+		binary.BigEndian.PutUint32(b[2:], id)
+		binary.BigEndian.PutUint16(b[6:], uint16(i))
+		binary.BigEndian.PutUint16(b[8:], uint16(count))
+		copy(b[fragmentHeader:], chunk)
+		datagrams = append(datagrams, b)
+	}
+	return
+}
+
+// add a datagram received, ok is true if a message completed. Every datagram must have a fragment header (a message
+// in one datagram too) so the peers must both enable fragmentation. An error wraps api.ErrMessageTooLarge returned if
+// the message exceeds max message or memory cap, or wraps api.ErrBadMessage if the datagram is not a valid fragment
+// (message dropped)
+func (r *reassembler) add(from string, datagram []byte) (data []byte, ok bool, err error) {
+	if len(datagram) < fragmentHeader || binary.BigEndian.Uint16(datagram) != fragmentMagic {
+		return nil, false, fmt.Errorf("%w: datagram from [%s] without fragment header", api.ErrBadMessage, from)
+	}
+	id := binary.BigEndian.Uint32(datagram[2:])
+	index := int(binary.BigEndian.Uint16(datagram[6:]))
+	count := int(binary.BigEndian.Uint16(datagram[8:]))
+	payload := datagram[fragmentHeader:]
+	if count == 1 && index == 0 {
+		return payload, true, nil
+	}
+	if count == 0 || index >= count {
+		return nil, false, fmt.Errorf("%w: invalid fragment [%d/%d] from [%s]", api.ErrBadMessage, index, count, from)
+	}
+	if (count-1)*(r.opt.GetMTU()-fragmentHeader) >= r.opt.GetMaxMessage() { //checked before allocating fragment slots
+		return nil, false, fmt.Errorf("%w: message from [%s] of %d fragments exceeds max message [%d]", api.ErrMessageTooLarge, from, count, r.opt.GetMaxMessage())
+	}
+	if len(payload) > r.opt.GetMTU()-fragmentHeader {
+		return nil, false, fmt.Errorf("%w: fragment from [%s] exceeds MTU [%d]", api.ErrBadMessage, from, r.opt.GetMTU())
+	}
+
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	now := time.Now()
+	r.purge(now)
+	key := fmt.Sprintf("%s#%d", from, id)
+	m, exist := r.messages[key]
+	if !exist {
+		cost := count * fragmentCost
+		if r.bytes+cost > r.opt.GetMaxMemory() {
+			return nil, false, fmt.Errorf("%w: message from [%s] dropped, reassembly memory exceeds [%d]", api.ErrMessageTooLarge, from, r.opt.GetMaxMemory())
+		}
+		m = &fragments{
+			parts:    make([][]byte, count),
+			cost:     cost,
+			deadline: now.Add(r.opt.GetTimeout()),
+		}
+		r.messages[key] = m
+		r.bytes += cost
+	}
+	if len(m.parts) != count {
+		r.drop(key, m)
+		return nil, false, fmt.Errorf("%w: invalid fragment [%d/%d] from [%s]", api.ErrBadMessage, index, count, from)
+	}
+	if m.parts[index] != nil {
+		return nil, false, nil //duplicated
+	}
+	if m.size+len(payload) > r.opt.GetMaxMessage() {
+		r.drop(key, m)
+		return nil, false, fmt.Errorf("%w: message from [%s] exceeds max message [%d]", api.ErrMessageTooLarge, from, r.opt.GetMaxMessage())
+	}
+	if r.bytes+len(payload) > r.opt.GetMaxMemory() {
+		r.drop(key, m)
+		return nil, false, fmt.Errorf("%w: message from [%s] dropped, reassembly memory exceeds [%d]", api.ErrMessageTooLarge, from, r.opt.GetMaxMemory())
+	}
+	m.parts[index] = append([]byte(nil), payload...)
+	m.received++
+	m.size += len(payload)
+	r.bytes += len(payload)
+	if m.received < count {
+		return nil, false, nil
+	}
+	data = make([]byte, 0, m.size)
+	for _, part := range m.parts {
+		data = append(data, part...)
+	}
+	r.drop(key, m)
+	return data, true, nil
+}
+
+// purge drop the incomplete messages timeout
+func (r *reassembler) purge(now time.Time) {
+	if now.Sub(r.purgeAt) < r.opt.GetTimeout()/4 {
+		return
+	}
+	r.purgeAt = now
+	for key, m := range r.messages {
+		if now.After(m.deadline) {
+			r.drop(key, m)
+		}
+	}
+}
+
+func (r *reassembler) drop(key string, m *fragments) {
+	r.bytes -= m.size + m.cost
+	delete(r.messages, key)
+}
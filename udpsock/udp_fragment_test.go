@@ -0,0 +1,81 @@
+package udpsock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/civet148/socketx/api"
+)
+
+func newFragmentHeader(id uint32, index, count uint16) []byte {
+	b := make([]byte, fragmentHeader)
+	binary.BigEndian.PutUint16(b[0:], fragmentMagic)
+	binary.BigEndian.PutUint32(b[2:], id)
+	binary.BigEndian.PutUint16(b[6:], index)
+	binary.BigEndian.PutUint16(b[8:], count)
+	return b
+}
+
+func TestFragmentReassemble(t *testing.T) {
+	opt := &api.UDPFragmentOption{MTU: 100}
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	datagrams, err := fragment(opt, 1, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newReassembler(opt)
+	for i := len(datagrams) - 1; i >= 0; i-- { //out of order
+		msg, ok, err := r.add("peer", datagrams[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (i == 0) {
+			t.Fatalf("fragment %d completed %v", i, ok)
+		}
+		if ok && !bytes.Equal(msg, data) {
+			t.Fatalf("message reassembled mismatch")
+		}
+	}
+	if r.bytes != 0 || len(r.messages) != 0 {
+		t.Fatalf("reassembly memory %d messages %d not released", r.bytes, len(r.messages))
+	}
+}
+
+func TestFragmentInvalid(t *testing.T) {
+	r := newReassembler(&api.UDPFragmentOption{})
+	cases := map[string][]byte{
+		"zero count":     newFragmentHeader(1, 5, 0),
+		"index overflow": newFragmentHeader(1, 3, 2),
+		"no header":      []byte("XF plain datagram"),
+		"short":          []byte("hello"),
+	}
+	for name, datagram := range cases {
+		if _, _, err := r.add("peer", datagram); !errors.Is(err, api.ErrBadMessage) {
+			t.Errorf("%s: expect ErrBadMessage, got %v", name, err)
+		}
+	}
+}
+
+func TestFragmentMemoryCap(t *testing.T) {
+	r := newReassembler(&api.UDPFragmentOption{MaxMemory: 64 * 1024})
+	//a 10 bytes datagram must not allocate slots of 65535 fragments
+	if _, _, err := r.add("peer", newFragmentHeader(1, 0, fragmentMax)); !errors.Is(err, api.ErrMessageTooLarge) {
+		t.Fatalf("expect ErrMessageTooLarge, got %v", err)
+	}
+	if len(r.messages) != 0 {
+		t.Fatalf("message slots allocated")
+	}
+	//the slots of incomplete messages are counted against max memory
+	var err error
+	for id := uint32(0); id < 1000 && err == nil; id++ {
+		_, _, err = r.add("peer", newFragmentHeader(id, 0, 700))
+	}
+	if !errors.Is(err, api.ErrMessageTooLarge) {
+		t.Fatalf("expect ErrMessageTooLarge, got %v", err)
+	}
+	if r.bytes > 64*1024 {
+		t.Fatalf("reassembly memory %d exceeds cap", r.bytes)
+	}
+}
@@ -0,0 +1,81 @@
+package udpsock
+
+import (
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/types"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+	"strconv"
+)
+
+// getInterface returns the network interface by url query 'iface', nil means system default
+func (s *socket) getInterface() (ifi *net.Interface, err error) {
+	name := s.ui.Queries[types.URL_QUERY_IFACE]
+	if name == "" {
+		return nil, nil
+	}
+	if ifi, err = net.InterfaceByName(name); err != nil {
+		return nil, log.Errorf("get network interface [%s] error [%s]", name, err.Error())
+	}
+	return
+}
+
+// setMulticast set multicast interface, TTL/hop limit and loopback of outgoing multicast datagrams by url queries,
+// nothing to do for a unicast socket without multicast queries
+func (s *socket) setMulticast(multicast bool) (err error) {
+	strIface := s.ui.Queries[types.URL_QUERY_IFACE]
+	strTTL := s.ui.Queries[types.URL_QUERY_TTL]
+	strLoop := s.ui.Queries[types.URL_QUERY_LOOP]
+	if !multicast && strIface == "" && strTTL == "" && strLoop == "" {
+		return
+	}
+	var ifi *net.Interface
+	if ifi, err = s.getInterface(); err != nil {
+		return
+	}
+	var ttl int
+	if strTTL != "" {
+		if ttl, err = strconv.Atoi(strTTL); err != nil || ttl < 0 || ttl > 255 {
+			return log.Errorf("invalid multicast ttl [%s]", strTTL)
+		}
+	}
+	var loop = true
+	if strLoop != "" {
+		if loop, err = strconv.ParseBool(strLoop); err != nil {
+			return log.Errorf("invalid multicast loop [%s]", strLoop)
+		}
+	}
+	if s.isUDP6() {
+		pc := ipv6.NewPacketConn(s.conn)
+		if ifi != nil {
+			if err = pc.SetMulticastInterface(ifi); err != nil {
+				return log.Errorf("set multicast interface [%s] error [%s]", ifi.Name, err.Error())
+			}
+		}
+		if strTTL != "" {
+			if err = pc.SetMulticastHopLimit(ttl); err != nil {
+				return log.Errorf("set multicast hop limit [%d] error [%s]", ttl, err.Error())
+			}
+		}
+		if err = pc.SetMulticastLoopback(loop); err != nil {
+			return log.Errorf("set multicast loopback [%v] error [%s]", loop, err.Error())
+		}
+		return
+	}
+	pc := ipv4.NewPacketConn(s.conn)
+	if ifi != nil {
+		if err = pc.SetMulticastInterface(ifi); err != nil {
+			return log.Errorf("set multicast interface [%s] error [%s]", ifi.Name, err.Error())
+		}
+	}
+	if strTTL != "" {
+		if err = pc.SetMulticastTTL(ttl); err != nil {
+			return log.Errorf("set multicast ttl [%d] error [%s]", ttl, err.Error())
+		}
+	}
+	if err = pc.SetMulticastLoopback(loop); err != nil {
+		return log.Errorf("set multicast loopback [%v] error [%s]", loop, err.Error())
+	}
+	return
+}
@@ -0,0 +1,78 @@
+package udpsock
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/civet148/gotools/parser"
+	"github.com/civet148/socketx/types"
+)
+
+// multicastInterface returns an interface up with multicast, loopback preferred
+func multicastInterface() (name string) {
+	ifis, _ := net.Interfaces()
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if ifi.Flags&net.FlagLoopback != 0 {
+			return ifi.Name
+		}
+		if name == "" {
+			name = ifi.Name
+		}
+	}
+	return
+}
+
+// the datagram sent to a multicast group is received by the member on the same host (loopback), the network of udp
+// scheme is inferred from the group address
+func TestMulticastLoopback(t *testing.T) {
+	iface := multicastInterface()
+	if iface == "" {
+		t.Skip("no network interface with multicast")
+	}
+	cases := []struct {
+		name    string
+		group   string
+		sender  string
+		network string
+	}{
+		{"IPv4", "239.1.2.3:0", "0.0.0.0:0", types.NETWORK_UDPv4},
+		{"IPv6", "[ff02::1:3]:0", "[::]:0", types.NETWORK_UDPv6},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := NewSocket(parser.ParseUrl("udp://" + c.group + "?iface=" + iface))
+			if network := r.(*socket).getNetwork(); network != c.network {
+				t.Fatalf("group [%s] network [%s]", c.group, network)
+			}
+			if err := r.Listen(); err != nil {
+				t.Skipf("join multicast group on [%s] error [%s]", iface, err)
+			}
+			defer r.Close()
+
+			s := NewSocket(parser.ParseUrl("udp://" + c.sender + "?iface=" + iface + "&ttl=1&loop=true"))
+			if err := s.Listen(); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			host, _, _ := net.SplitHostPort(c.group)
+			_, port, _ := net.SplitHostPort(r.GetLocalAddr())
+			if _, err := s.Send([]byte("hello"), net.JoinHostPort(host, port)); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			msg, err := r.RecvContext(ctx, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg.Data) != "hello" {
+				t.Fatalf("receive [%s]", msg.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package udpsock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"github.com/civet148/socketx/types"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// session is a virtual connection of a remote address on the listening UDP socket (session mode)
+type session struct {
+	parent *socket       //listening socket
+	peer   *net.UDPAddr  //remote address
+	inbox  chan []byte   //datagrams received
+	quit   chan struct{} //closed when session closed
+	once   sync.Once     //close once
+	active int64         //last active time (unix nano)
+}
+
+func newSession(parent *socket, peer *net.UDPAddr) *session {
+	ss := &session{
+		parent: parent,
+		peer:   peer,
+		inbox:  make(chan []byte, parent.sessOpt.GetQueueSize()),
+		quit:   make(chan struct{}),
+	}
+	ss.touch()
+	return ss
+}
+
+func (ss *session) Listen() (err error) {
+	return fmt.Errorf("listen method not for UDP session")
+}
+
+func (ss *session) Accept() api.Socket {
+	log.Warnf("accept method not for UDP session")
+	return nil
+}
+
+func (ss *session) Connect() (err error) {
+	return fmt.Errorf("connect method not for UDP session")
+}
+
+func (ss *session) ConnectContext(ctx context.Context) (err error) {
+	return ss.Connect()
+}
+
+// Send send data to the remote address of session, the to parameter is ignored
+func (ss *session) Send(data []byte, to ...string) (n int, err error) {
+	select {
+	case <-ss.quit:
+		return 0, fmt.Errorf("UDP session [%s] closed", ss.peer)
+	default:
+	}
+	ss.touch()
+	return ss.parent.writeTo(data, ss.peer)
+}
+
+func (ss *session) SendContext(ctx context.Context, data []byte, to ...string) (n int, err error) {
+	if err = ctx.Err(); err != nil {
+		return 0, api.NewTimeoutError("send", err)
+	}
+	return ss.Send(data, to...)
+}
+
+func (ss *session) SendJson(v interface{}, to ...string) (n int, err error) {
+	var data []byte
+	data, err = json.Marshal(v)
+	if err != nil {
+		return 0, log.Errorf(err.Error())
+	}
+	return ss.Send(data, to...)
+}
+
+// Recv receive a datagram of session, the length parameter is ignored
+func (ss *session) Recv(length int) (msg *api.SockMessage, err error) {
+	return ss.RecvContext(context.Background(), length)
+}
+
+func (ss *session) RecvContext(ctx context.Context, length int) (msg *api.SockMessage, err error) {
+	select {
+	case data := <-ss.inbox:
+		return ss.makeMessage(data), nil
+	default:
+	}
+	select {
+	case data := <-ss.inbox:
+		return ss.makeMessage(data), nil
+	case <-ss.quit:
+		return nil, fmt.Errorf("UDP session [%s] closed", ss.peer)
+	case <-ctx.Done():
+		return nil, api.NewTimeoutError("recv", ctx.Err())
+	}
+}
+
+func (ss *session) Close() (err error) {
+	err = fmt.Errorf("socket already closed")
+	ss.once.Do(func() {
+		err = nil
+		close(ss.quit)
+		ss.parent.removeSession(ss)
+	})
+	return
+}
+
+func (ss *session) GetLocalAddr() string {
+	return ss.parent.GetLocalAddr()
+}
+
+func (ss *session) GetRemoteAddr() string {
+	return ss.peer.String()
+}
+
+func (ss *session) GetSocketType() types.SocketType {
+	return types.SocketType_UDP
+}
+
+// deliver queue the datagram received, dropped if the queue is full
+func (ss *session) deliver(data []byte) {
+	ss.touch()
+	select {
+	case ss.inbox <- data:
+	default:
+		log.Warnf("UDP session [%s] queue is full, datagram dropped", ss.peer)
+	}
+}
+
+func (ss *session) touch() {
+	atomic.StoreInt64(&ss.active, time.Now().UnixNano())
+}
+
+// idle returns the duration since last datagram received or sent
+func (ss *session) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&ss.active)))
+}
+
+func (ss *session) makeMessage(data []byte) *api.SockMessage {
+	return &api.SockMessage{
+		Sock: ss,
+		Data: data,
+		From: ss.peer.String(),
+	}
+}
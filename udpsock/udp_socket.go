@@ -1,7 +1,9 @@
 package udpsock
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/civet148/gotools/parser"
 	"github.com/civet148/log"
@@ -10,13 +12,24 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type socket struct {
-	ui     *parser.UrlInfo
-	conn   *net.UDPConn
-	closed bool
-	locker sync.RWMutex
+	ui         *parser.UrlInfo
+	conn       *net.UDPConn
+	closed     bool
+	locker     sync.RWMutex
+	sessOpt    *api.UDPSessionOption  //session mode option, nil means disabled
+	sessions   map[string]*session    //sessions by remote address
+	sessLocker sync.Mutex             //sessions locker
+	accepting  chan *session          //new sessions to accept
+	quit       chan struct{}          //closed when socket closed (session mode)
+	done       chan struct{}          //closed when session dispatcher exited
+	fragOpt    *api.UDPFragmentOption //fragmentation option, nil means disabled
+	reasm      *reassembler           //fragments reassembler
+	msgID      uint32                 //last fragmented message id
 }
 
 func init() {
@@ -25,9 +38,15 @@ func init() {
 
 func NewSocket(ui *parser.UrlInfo, options ...api.SocketOption) api.Socket {
 
-	return &socket{
-		ui: ui,
+	s := &socket{
+		ui:      ui,
+		sessOpt: api.GetUDPSession(options...),
+		fragOpt: api.GetUDPFragment(options...),
 	}
+	if s.fragOpt != nil {
+		s.reasm = newReassembler(s.fragOpt)
+	}
+	return s
 }
 
 func (s *socket) Listen() (err error) {
@@ -40,21 +59,59 @@ func (s *socket) Listen() (err error) {
 		return log.Errorf("resolve UDP addr [%v] error [%v]", strAddr, err.Error())
 	}
 
-	if s.conn, err = net.ListenUDP(network, udpAddr); err != nil {
-		return log.Errorf("listen UDP addr [%v] error [%v]", strAddr, err.Error())
+	if udpAddr.IP.IsMulticast() {
+		var ifi *net.Interface
+		if ifi, err = s.getInterface(); err != nil {
+			return err
+		}
+		if s.conn, err = net.ListenMulticastUDP(network, ifi, udpAddr); err != nil {
+			return log.Errorf("listen UDP multicast addr [%v] error [%v]", strAddr, err.Error())
+		}
+	} else {
+		if udpAddr.IP.Equal(net.IPv4bcast) {
+			udpAddr.IP = nil //receive broadcast datagrams by listening on all addresses
+		}
+		if s.conn, err = net.ListenUDP(network, udpAddr); err != nil {
+			return log.Errorf("listen UDP addr [%v] error [%v]", strAddr, err.Error())
+		}
+	}
+	if err = s.setMulticast(udpAddr.IP.IsMulticast()); err != nil {
+		_ = s.conn.Close()
+		return err
+	}
+	if s.sessOpt != nil {
+		s.sessions = make(map[string]*session)
+		s.accepting = make(chan *session, 1000)
+		s.quit = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.dispatch()
+		go s.expire()
 	}
 	return
 }
 
+// Accept returns a new session of remote address in session mode, nil returned when socket closed
 func (s *socket) Accept() api.Socket {
-	log.Warnf("accept method not for UDP socket")
-	return nil
+	if s.sessOpt == nil {
+		log.Warnf("accept method only for UDP session mode")
+		return nil
+	}
+	select {
+	case <-s.quit:
+		return nil
+	case ss := <-s.accepting:
+		return ss
+	}
 }
 
 func (s *socket) Connect() (err error) {
 	return fmt.Errorf("only for TCP/WEB socket")
 }
 
+func (s *socket) ConnectContext(ctx context.Context) (err error) {
+	return s.Connect()
+}
+
 func (s *socket) Send(data []byte, to ...string) (n int, err error) {
 
 	var udpAddr *net.UDPAddr
@@ -63,8 +120,6 @@ func (s *socket) Send(data []byte, to ...string) (n int, err error) {
 	if len(to) == 0 {
 		return 0, fmt.Errorf("UDP send method to parameter required")
 	}
-	s.locker.Lock()
-	defer s.locker.Unlock()
 	strToAddr := to[0]
 	nSep := len(parser.URL_SCHEME_SEP)
 	if strings.Contains(strToAddr, parser.URL_SCHEME_SEP) {
@@ -75,7 +130,18 @@ func (s *socket) Send(data []byte, to ...string) (n int, err error) {
 	if udpAddr, err = net.ResolveUDPAddr(network, strToAddr); err != nil {
 		return 0, log.Errorf("resolve UDP addr [%v] error [%v]", strToAddr, err.Error())
 	}
-	return s.conn.WriteToUDP(data, udpAddr)
+	return s.writeTo(data, udpAddr)
+}
+
+func (s *socket) SendContext(ctx context.Context, data []byte, to ...string) (n int, err error) {
+	if s.conn == nil {
+		return 0, fmt.Errorf("socket is nil")
+	}
+	err = api.RunContext(ctx, "send", s.conn.SetWriteDeadline, func() (e error) {
+		n, e = s.Send(data, to...)
+		return
+	})
+	return
 }
 
 func (s *socket) SendJson(v interface{}, to ...string) (n int, err error) {
@@ -87,21 +153,40 @@ func (s *socket) SendJson(v interface{}, to ...string) (n int, err error) {
 	return s.Send(data, to...)
 }
 
+func (s *socket) RecvContext(ctx context.Context, length int) (msg *api.SockMessage, err error) {
+	if s.conn == nil {
+		return nil, fmt.Errorf("socket is nil")
+	}
+	err = api.RunContext(ctx, "recv", s.conn.SetReadDeadline, func() (e error) {
+		msg, e = s.Recv(length)
+		return
+	})
+	return
+}
+
+// Recv receive a datagram (or a reassembled message if fragmentation enabled), length is the receive buffer size,
+// default PACK_FRAGMENT_MAX=1500 bytes (or MTU if fragmentation enabled). An error wraps api.ErrMessageTooLarge
+// returned if the datagram exceeds the receive buffer (or api.ErrBadMessage if not a valid fragment), the datagram is
+// dropped and the socket is still usable.
 func (s *socket) Recv(length int) (msg *api.SockMessage, err error) {
-	var n int
+	var data []byte
 	var udpAddr *net.UDPAddr
-	data := s.makeBuffer(types.PACK_FRAGMENT_MAX)
-	if n, udpAddr, err = s.conn.ReadFromUDP(data); err != nil {
-		log.Errorf("read from UDP error [%v]", err.Error())
-		return
+	for {
+		if data, udpAddr, err = s.readFrom(length); err != nil {
+			return
+		}
+		if data != nil {
+			break
+		}
 	}
 	return &api.SockMessage{
 		Sock: s,
-		Data: data[:n],
+		Data: data,
 		From: udpAddr.String(),
 	}, nil
 }
 
+// Close close socket, in session mode the connection keeps serving the accepted sessions until all of them closed
 func (s *socket) Close() (err error) {
 	if s.closed {
 		return fmt.Errorf("socket already closed")
@@ -109,10 +194,33 @@ func (s *socket) Close() (err error) {
 	if s.conn == nil {
 		return fmt.Errorf("socket is nil")
 	}
+	if s.sessOpt != nil {
+		return s.closeSessions()
+	}
 	s.closed = true
 	return s.conn.Close()
 }
 
+// closeSessions stop accepting sessions, close the sessions not accepted yet and close the connection if no session
+func (s *socket) closeSessions() (err error) {
+	s.sessLocker.Lock()
+	s.closed = true
+	close(s.quit)
+	idle := len(s.sessions) == 0
+	s.sessLocker.Unlock()
+	if idle {
+		return s.conn.Close()
+	}
+	for {
+		select {
+		case ss := <-s.accepting:
+			_ = ss.Close()
+		default:
+			return
+		}
+	}
+}
+
 func (s *socket) GetLocalAddr() string {
 	return s.conn.LocalAddr().String()
 }
@@ -132,14 +240,168 @@ func (s *socket) getNetwork() string {
 	return types.NETWORK_UDPv4
 }
 
+// isUDP6 returns true for udp6 scheme, or udp scheme with an IPv6 address (eg. udp://[ff02::1]:9999)
 func (s *socket) isUDP6() (ok bool) {
-	scheme := s.ui.GetScheme()
-	if scheme == types.URL_SCHEME_UDP6 {
+	switch s.ui.GetScheme() {
+	case types.URL_SCHEME_UDP6:
 		return true
+	case types.URL_SCHEME_UDP:
+		host, _, err := net.SplitHostPort(s.ui.GetHost())
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.To4() == nil
+	}
+	return
+}
+
+// readFrom read a datagram and reassemble fragments, data is nil if the message is not complete
+func (s *socket) readFrom(length int) (data []byte, udpAddr *net.UDPAddr, err error) {
+	size := s.getBufferSize(length)
+	buf := s.makeBuffer(size + 1) //one more byte to detect oversize datagram
+	var n int
+	if n, udpAddr, err = s.conn.ReadFromUDP(buf); err != nil {
+		if !errors.Is(err, net.ErrClosed) {
+			log.Errorf("read from UDP error [%v]", err.Error())
+		}
+		return
+	}
+	if n > size {
+		err = fmt.Errorf("%w: datagram from [%s] exceeds receive buffer [%d] bytes", api.ErrMessageTooLarge, udpAddr, size)
+		log.Warnf(err.Error())
+		return nil, udpAddr, err
+	}
+	if s.reasm == nil {
+		return buf[:n], udpAddr, nil
+	}
+	var ok bool
+	if data, ok, err = s.reasm.add(udpAddr.String(), buf[:n]); err != nil {
+		log.Warnf(err.Error())
+		return nil, udpAddr, err
+	}
+	if !ok {
+		return nil, udpAddr, nil
 	}
 	return
 }
 
+// getBufferSize returns receive buffer size of a datagram
+func (s *socket) getBufferSize(length int) int {
+	if length <= 0 {
+		length = types.PACK_FRAGMENT_MAX
+	}
+	if s.fragOpt != nil && length < s.fragOpt.GetMTU() {
+		length = s.fragOpt.GetMTU()
+	}
+	return length
+}
+
+// dispatch read datagrams and deliver them to sessions, a new session created for the first datagram of remote address
+func (s *socket) dispatch() {
+	defer close(s.done)
+	for {
+		data, udpAddr, err := s.readFrom(-1)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		if ss := s.getSession(udpAddr); ss != nil {
+			ss.deliver(data)
+		}
+	}
+}
+
+// expire close the sessions idle longer than the idle timeout
+func (s *socket) expire() {
+	timeout := s.sessOpt.GetIdleTimeout()
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		var idles []*session
+		s.sessLocker.Lock()
+		for _, ss := range s.sessions {
+			if ss.idle() >= timeout {
+				idles = append(idles, ss)
+			}
+		}
+		s.sessLocker.Unlock()
+		for _, ss := range idles {
+			log.Debugf("UDP session [%s] idle timeout", ss.GetRemoteAddr())
+			_ = ss.Close()
+		}
+	}
+}
+
+// getSession returns the session of remote address, nil returned if socket closed (no more session accepted)
+func (s *socket) getSession(udpAddr *net.UDPAddr) *session {
+	s.sessLocker.Lock()
+	defer s.sessLocker.Unlock()
+	strAddr := udpAddr.String()
+	if ss, ok := s.sessions[strAddr]; ok {
+		return ss
+	}
+	select {
+	case <-s.quit:
+		return nil
+	default:
+	}
+	ss := newSession(s, udpAddr)
+	select {
+	case s.accepting <- ss:
+		s.sessions[strAddr] = ss
+		return ss
+	default:
+		log.Warnf("UDP session [%s] dropped, too many sessions not accepted", strAddr)
+		return nil
+	}
+}
+
+// removeSession remove session and close connection if socket closed and no more session
+func (s *socket) removeSession(ss *session) {
+	s.sessLocker.Lock()
+	defer s.sessLocker.Unlock()
+	strAddr := ss.peer.String()
+	if s.sessions[strAddr] == ss {
+		delete(s.sessions, strAddr)
+	}
+	if s.closed && len(s.sessions) == 0 {
+		_ = s.conn.Close()
+	}
+}
+
+// writeTo send data to address, the data is sent as fragments if fragmentation enabled
+func (s *socket) writeTo(data []byte, udpAddr *net.UDPAddr) (n int, err error) {
+	if s.fragOpt == nil {
+		s.locker.Lock()
+		defer s.locker.Unlock()
+		return s.conn.WriteToUDP(data, udpAddr)
+	}
+	var datagrams [][]byte
+	if datagrams, err = fragment(s.fragOpt, atomic.AddUint32(&s.msgID, 1), data); err != nil {
+		log.Errorf(err.Error())
+		return 0, err
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for _, b := range datagrams {
+		if _, err = s.conn.WriteToUDP(b, udpAddr); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
 func (s *socket) makeBuffer(length int) []byte {
 	return make([]byte, length)
 }
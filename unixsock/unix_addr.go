@@ -0,0 +1,53 @@
+package unixsock
+
+import (
+	"fmt"
+	"github.com/civet148/gotools/parser"
+	"runtime"
+	"strings"
+)
+
+const unixPathMax = 108 // size of sun_path in sockaddr_un
+
+// getUnixAddr returns the socket file path or the abstract name (starts with '@', linux only) of url
+func getUnixAddr(ui *parser.UrlInfo) (addr string, err error) {
+	if ui == nil {
+		return "", fmt.Errorf("unix socket url is nil")
+	}
+	addr = ui.GetPath()
+	if err = checkUnixAddr(addr); err != nil {
+		return "", err
+	}
+	return
+}
+
+// checkUnixAddr validate socket file path or abstract name
+func checkUnixAddr(addr string) (err error) {
+	if addr == "" {
+		return fmt.Errorf("unix socket path is empty")
+	}
+	if isAbstract(addr) {
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("unix socket abstract name [%s] only supported on linux", addr)
+		}
+		if len(addr) == 1 {
+			return fmt.Errorf("unix socket abstract name is empty")
+		}
+		if len(addr) > unixPathMax {
+			return fmt.Errorf("unix socket abstract name [%s] longer than %d bytes", addr, unixPathMax-1)
+		}
+		return
+	}
+	if len(addr) >= unixPathMax { //terminating zero required
+		return fmt.Errorf("unix socket path [%s] longer than %d bytes", addr, unixPathMax-1)
+	}
+	if strings.HasSuffix(addr, "/") {
+		return fmt.Errorf("unix socket path [%s] is a directory", addr)
+	}
+	return
+}
+
+// isAbstract returns true if the address is in linux abstract namespace (no socket file)
+func isAbstract(addr string) bool {
+	return strings.HasPrefix(addr, "@")
+}
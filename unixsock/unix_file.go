@@ -0,0 +1,157 @@
+package unixsock
+
+import (
+	"errors"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const probeTimeout = time.Second // timeout of probing a live server on the socket file
+
+// sockFile socket file of a listening UNIX socket
+type sockFile struct {
+	addr string
+	opt  *api.UnixFileOption
+	lock *os.File    //lock file, nil if not locked
+	fi   os.FileInfo //socket file bound, nil if not bound
+}
+
+// prepareSockFile lock the lock file and remove the stale socket file before listening. The socket file is removed
+// only if no server accepts on it, an error returned if it is not a socket file or a live server is using it
+func prepareSockFile(network, addr string, opt *api.UnixFileOption) (sf *sockFile, err error) {
+	sf = &sockFile{
+		addr: addr,
+		opt:  opt,
+	}
+	if isAbstract(addr) {
+		return sf, nil
+	}
+	if opt != nil && opt.LockFile {
+		if sf.lock, err = lockFile(addr + ".lock"); err != nil {
+			return nil, err
+		}
+	}
+	if err = sf.removeStale(network); err != nil {
+		sf.unlock()
+		return nil, err
+	}
+	return sf, nil
+}
+
+func (sf *sockFile) removeStale(network string) (err error) {
+	var fi os.FileInfo
+	if fi, err = os.Lstat(sf.addr); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return log.Errorf("stat socket file [%s] error [%s]", sf.addr, err.Error())
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return log.Errorf("file [%s] is not a socket, refuse to remove it", sf.addr)
+	}
+	var conn net.Conn
+	if conn, err = net.DialTimeout(network, sf.addr, probeTimeout); err == nil {
+		_ = conn.Close()
+		return log.Errorf("socket file [%s] is in use by a live server", sf.addr)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return log.Errorf("probe socket file [%s] error [%s], refuse to remove it", sf.addr, err.Error())
+	}
+	log.Infof("remove stale socket file [%s]", sf.addr)
+	if err = os.Remove(sf.addr); err != nil {
+		return log.Errorf("remove file error [%v]", err.Error())
+	}
+	return nil
+}
+
+// setup set mode, owner and group of the socket file after listening
+func (sf *sockFile) setup() (err error) {
+	if sf == nil || isAbstract(sf.addr) {
+		return
+	}
+	if sf.fi, err = os.Lstat(sf.addr); err != nil {
+		return log.Errorf("stat socket file [%s] error [%s]", sf.addr, err.Error())
+	}
+	if sf.opt == nil {
+		return
+	}
+	if sf.opt.Mode != 0 {
+		if err = os.Chmod(sf.addr, sf.opt.Mode); err != nil {
+			return log.Errorf("chmod socket file [%s] error [%s]", sf.addr, err.Error())
+		}
+	}
+	if sf.opt.Owner == "" && sf.opt.Group == "" {
+		return
+	}
+	uid, gid := -1, -1
+	if sf.opt.Owner != "" {
+		if uid, err = lookupID(sf.opt.Owner, func(name string) (string, error) {
+			u, e := user.Lookup(name)
+			if e != nil {
+				return "", e
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return log.Errorf("lookup owner [%s] error [%s]", sf.opt.Owner, err.Error())
+		}
+	}
+	if sf.opt.Group != "" {
+		if gid, err = lookupID(sf.opt.Group, func(name string) (string, error) {
+			g, e := user.LookupGroup(name)
+			if e != nil {
+				return "", e
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return log.Errorf("lookup group [%s] error [%s]", sf.opt.Group, err.Error())
+		}
+	}
+	if err = os.Chown(sf.addr, uid, gid); err != nil {
+		return log.Errorf("chown socket file [%s] error [%s]", sf.addr, err.Error())
+	}
+	return
+}
+
+// remove the socket file and release the lock file after listening socket closed, the socket file is kept if it is not
+// the one bound (eg. removed and bound by another server)
+func (sf *sockFile) remove() {
+	if sf == nil {
+		return
+	}
+	defer sf.unlock()
+	if isAbstract(sf.addr) || sf.fi == nil {
+		return
+	}
+	if fi, err := os.Lstat(sf.addr); err == nil && os.SameFile(fi, sf.fi) {
+		if err = os.Remove(sf.addr); err != nil {
+			log.Warnf("remove socket file [%s] error [%s]", sf.addr, err.Error())
+		}
+	}
+}
+
+// unlock release the lock file, the lock file is kept to avoid racing with a server locking it
+func (sf *sockFile) unlock() {
+	if sf == nil || sf.lock == nil {
+		return
+	}
+	_ = sf.lock.Close()
+	sf.lock = nil
+}
+
+// lookupID returns the numeric id or the id looked up by name
+func lookupID(name string, lookup func(name string) (string, error)) (id int, err error) {
+	if id, err = strconv.Atoi(name); err == nil {
+		return
+	}
+	var strID string
+	if strID, err = lookup(name); err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(strID)
+}
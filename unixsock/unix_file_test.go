@@ -0,0 +1,43 @@
+package unixsock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/civet148/gotools/parser"
+	"github.com/civet148/socketx/api"
+)
+
+// closing a server must not remove the socket file bound by a new server on the same path
+func TestSockFileReplaced(t *testing.T) {
+	for _, scheme := range []string{"unix", "unixgram"} {
+		path := filepath.Join(t.TempDir(), scheme+".sock")
+		newSocket := func() api.Socket {
+			ui := parser.ParseUrl(scheme + "://" + path)
+			if scheme == "unixgram" {
+				return NewGramSocket(ui)
+			}
+			return NewSocket(ui)
+		}
+		old := newSocket()
+		if err := old.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		s := newSocket()
+		if err := s.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		_ = old.Close()
+		if _, err := os.Lstat(path); err != nil {
+			t.Fatalf("%s: socket file of new server removed by old server", scheme)
+		}
+		_ = s.Close()
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s: socket file not removed after closed", scheme)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package unixsock
+
+import (
+	"errors"
+	"github.com/civet148/log"
+	"os"
+	"syscall"
+)
+
+// lockFile lock the file exclusively (flock), the lock is released when the file closed or process exited
+func lockFile(path string) (f *os.File, err error) {
+	if f, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600); err != nil {
+		return nil, log.Errorf("open lock file [%s] error [%s]", path, err.Error())
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, log.Errorf("lock file [%s] is locked by another server", path)
+		}
+		return nil, log.Errorf("lock file [%s] error [%s]", path, err.Error())
+	}
+	return f, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package unixsock
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile only supported on linux
+func lockFile(path string) (f *os.File, err error) {
+	return nil, fmt.Errorf("lock file [%s] only supported on linux", path)
+}
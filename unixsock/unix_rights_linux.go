@@ -0,0 +1,138 @@
+//go:build linux
+// +build linux
+
+package unixsock
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"net"
+	"os"
+	"syscall"
+)
+
+const maxFiles = 253 // SCM_MAX_FD, max files of a message
+
+// read from connection, the files received (SCM_RIGHTS) are kept with the stream offset of the last byte read. The
+// kernel stops reading after the data sent with the files, so the last byte belongs to the message sent with them even
+// if the data of earlier messages is read together
+func (s *socket) read(p []byte) (n int, err error) {
+	uc, ok := s.conn.(*net.UnixConn)
+	if !ok {
+		n, err = s.conn.Read(p)
+		s.nread += int64(n)
+		return
+	}
+	if s.oob == nil {
+		s.oob = make([]byte, syscall.CmsgSpace(maxFiles*4))
+	}
+	var oobn, flags int
+	if n, oobn, flags, _, err = uc.ReadMsgUnix(p, s.oob); n < 0 {
+		n = 0 //io.Reader must not return a negative count
+	}
+	s.nread += int64(n)
+	if oobn > 0 || flags&syscall.MSG_CTRUNC != 0 {
+		r := rights{at: s.nread - 1}
+		r.files, r.err = parseRights(s.oob[:oobn])
+		if flags&syscall.MSG_CTRUNC != 0 {
+			r.err = fmt.Errorf("files from [%s] truncated, more than %d files sent", s.GetRemoteAddr(), maxFiles)
+		}
+		if r.err != nil {
+			log.Warnf("receive files from [%s] error [%s]", s.GetRemoteAddr(), r.err.Error())
+		}
+		s.fLocker.Lock()
+		s.files = append(s.files, r)
+		s.fLocker.Unlock()
+	}
+	return
+}
+
+// SendFiles send data with open files (SCM_RIGHTS), the files are duplicated to peer and can be closed after sent
+func (s *socket) SendFiles(data []byte, files ...*os.File) (n int, err error) {
+	uc, ok := s.conn.(*net.UnixConn)
+	if !ok {
+		return 0, fmt.Errorf("send files only supported by UNIX stream socket without TLS")
+	}
+	if len(files) > maxFiles {
+		return 0, fmt.Errorf("send files count [%d] exceeds %d", len(files), maxFiles)
+	}
+	var fds []int
+	for _, f := range files {
+		fds = append(fds, int(f.Fd()))
+	}
+	frame := data
+	if s.framer != nil {
+		if frame, err = s.framer.Encode(data); err != nil {
+			return 0, log.Errorf("encode message with framer [%s] error [%s]", s.framer.Name(), err.Error())
+		}
+	}
+	if len(frame) == 0 {
+		return 0, fmt.Errorf("send files requires data")
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	var sent int
+	if sent, _, err = uc.WriteMsgUnix(frame, syscall.UnixRights(fds...), nil); err != nil {
+		return 0, log.Errorf("send files to [%s] error [%s]", s.GetRemoteAddr(), err.Error())
+	}
+	if sent < len(frame) {
+		if _, err = uc.Write(frame[sent:]); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// GetPeerCredential returns the credential of peer process when connected (SO_PEERCRED)
+func (s *socket) GetPeerCredential() (cred *api.PeerCredential, err error) {
+	conn := s.conn
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("peer credential only supported by connected UNIX stream socket")
+	}
+	var rc syscall.RawConn
+	if rc, err = uc.SyscallConn(); err != nil {
+		return nil, err
+	}
+	var ucred *syscall.Ucred
+	var e error
+	if err = rc.Control(func(fd uintptr) {
+		ucred, e = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if e != nil {
+		return nil, log.Errorf("get peer credential of [%s] error [%s]", s.GetRemoteAddr(), e.Error())
+	}
+	return &api.PeerCredential{
+		PID: int(ucred.Pid),
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+	}, nil
+}
+
+// parseRights returns the files of SCM_RIGHTS control messages
+func parseRights(oob []byte) (files []*os.File, err error) {
+	var msgs []syscall.SocketControlMessage
+	if msgs, err = syscall.ParseSocketControlMessage(oob); err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level != syscall.SOL_SOCKET || msg.Header.Type != syscall.SCM_RIGHTS {
+			continue
+		}
+		var fds []int
+		if fds, err = syscall.ParseUnixRights(&msg); err != nil {
+			return
+		}
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), fmt.Sprintf("fd-%d", fd)))
+		}
+	}
+	return
+}
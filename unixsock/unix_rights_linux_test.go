@@ -0,0 +1,204 @@
+//go:build linux
+// +build linux
+
+package unixsock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/civet148/gotools/parser"
+	"github.com/civet148/socketx/api"
+)
+
+const helperEnv = "SOCKETX_TEST_UNIX_URL"
+
+// TestHelperSendFiles is the peer process of TestSendFilesProcess, it sends a file opened and waits for the reply
+func TestHelperSendFiles(t *testing.T) {
+	url := os.Getenv(helperEnv)
+	if url == "" {
+		return
+	}
+	s := NewSocket(parser.ParseUrl(url))
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	f, err := os.CreateTemp("", "socketx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = f.WriteString("passed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.(api.FileSender).SendFiles([]byte("file"), f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Recv(-1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the file sent by another process is received and the credential of the process is got
+func TestSendFilesProcess(t *testing.T) {
+	url := "unix://" + filepath.Join(t.TempDir(), "rights.sock") + "?framer=len4"
+	ls := NewSocket(parser.ParseUrl(url))
+	if err := ls.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperSendFiles$")
+	cmd.Env = append(os.Environ(), helperEnv+"="+url)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("peer process error [%s] output:\n%s", err, out.String())
+		}
+	}()
+
+	accepted := make(chan api.Socket, 1)
+	go func() {
+		accepted <- ls.Accept()
+	}()
+	var s api.Socket
+	select {
+	case s = <-accepted:
+	case <-time.After(5 * time.Second):
+	}
+	if s == nil {
+		t.Fatal("accept failed")
+	}
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := s.RecvContext(ctx, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Files) != 1 {
+		t.Fatalf("expect 1 file, got %d", len(msg.Files))
+	}
+	f := msg.Files[0]
+	defer f.Close()
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "file" || string(data) != "passed" {
+		t.Fatalf("receive [%s] file content [%s]", msg.Data, data)
+	}
+
+	cred, err := s.(api.CredentialSocket).GetPeerCredential()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.PID != cmd.Process.Pid || cred.UID != uint32(os.Getuid()) || cred.GID != uint32(os.Getgid()) {
+		t.Fatalf("peer credential %+v of process %d", cred, cmd.Process.Pid)
+	}
+	if _, err = s.Send([]byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// connectPair returns a connected and an accepted UNIX stream socket of url
+func connectPair(t *testing.T, url string) (c, s api.Socket) {
+	ls := NewSocket(parser.ParseUrl(url))
+	if err := ls.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ls.Close() })
+	accepted := make(chan api.Socket, 1)
+	go func() {
+		accepted <- ls.Accept()
+	}()
+	c = NewSocket(parser.ParseUrl(url))
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if s = <-accepted; s == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return
+}
+
+// the files are returned with the message sent with them even if the messages before are read together
+func TestSendFilesOffset(t *testing.T) {
+	c, s := connectPair(t, "unix://"+filepath.Join(t.TempDir(), "offset.sock")+"?framer=len4")
+	f, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = c.Send([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.(api.FileSender).SendFiles([]byte("second"), f, f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Send([]byte("third")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) //the messages are read by one read
+	for _, want := range []struct {
+		data  string
+		files int
+	}{{"first", 0}, {"second", 2}, {"third", 0}} {
+		msg, err := s.Recv(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Data) != want.data || len(msg.Files) != want.files {
+			t.Fatalf("receive [%s] with %d files, want [%s] with %d files", msg.Data, len(msg.Files), want.data, want.files)
+		}
+		for _, f := range msg.Files {
+			_ = f.Close()
+		}
+	}
+}
+
+// the message with files truncated is dropped and the socket is still usable
+func TestSendFilesTruncated(t *testing.T) {
+	c, s := connectPair(t, "unix://"+filepath.Join(t.TempDir(), "truncated.sock")+"?framer=len4")
+	s.(*socket).oob = make([]byte, syscall.CmsgSpace(4)) //room for one file only
+	f, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = c.(api.FileSender).SendFiles([]byte("files"), f, f, f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Send([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Recv(-1); !errors.Is(err, api.ErrBadMessage) {
+		t.Fatalf("receive files truncated returns [%v]", err)
+	}
+	msg, err := s.Recv(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "next" || len(msg.Files) != 0 {
+		t.Fatalf("receive [%s] with %d files after truncated", msg.Data, len(msg.Files))
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package unixsock
+
+import (
+	"fmt"
+	"github.com/civet148/socketx/api"
+	"os"
+)
+
+func (s *socket) read(p []byte) (n int, err error) {
+	n, err = s.conn.Read(p)
+	s.nread += int64(n)
+	return
+}
+
+// SendFiles only supported on linux
+func (s *socket) SendFiles(data []byte, files ...*os.File) (n int, err error) {
+	return 0, fmt.Errorf("send files only supported on linux")
+}
+
+// GetPeerCredential only supported on linux
+func (s *socket) GetPeerCredential() (cred *api.PeerCredential, err error) {
+	return nil, fmt.Errorf("peer credential only supported on linux")
+}
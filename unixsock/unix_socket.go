@@ -1,6 +1,10 @@
 package unixsock
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/civet148/gotools/parser"
@@ -9,16 +13,33 @@ import (
 	"github.com/civet148/socketx/types"
 	"net"
 	"os"
-	"strings"
 	"sync"
+	"time"
 )
 
 type socket struct {
 	ui       *parser.UrlInfo
 	conn     net.Conn
-	listener *net.UnixListener
+	listener net.Listener
 	closed   bool
+	cLocker  sync.Mutex //close locker
 	locker   sync.RWMutex
+	framer   api.Framer           //message framer, nil means raw stream
+	reader   *bufio.Reader        //buffered reader for framer
+	nread    int64                //bytes read from connection
+	partial  bool                 //the last frame failed after read partially, the stream is out of sync
+	wpartial bool                 //the last frame failed after written partially, the stream is out of sync
+	err      error                //socket option error
+	hbOpt    *api.HeartbeatOption //heartbeat option, nil means disabled
+	hbLocker sync.Mutex           //heartbeat locker
+	pingAt   time.Time            //last ping sent time
+	pongAt   time.Time            //last pong received time
+	rtt      time.Duration        //last round trip time
+	options  []api.SocketOption   //socket options
+	files    []rights             //files received and not returned by a message yet (linux)
+	fLocker  sync.Mutex           //files locker
+	oob      []byte               //ancillary data buffer to receive files (linux)
+	sf       *sockFile            //socket file of listening socket, removed when closed
 }
 
 func init() {
@@ -26,34 +47,63 @@ func init() {
 }
 
 func NewSocket(ui *parser.UrlInfo, options ...api.SocketOption) api.Socket {
-
+	framer, err := api.GetFramer(ui, options...)
+	if err != nil {
+		log.Errorf("get framer error [%s]", err.Error())
+	}
+	if err == nil {
+		if _, err = getUnixAddr(ui); err != nil {
+			log.Errorf(err.Error())
+		}
+	}
 	return &socket{
-		ui: ui,
+		ui:      ui,
+		framer:  framer,
+		err:     err,
+		hbOpt:   api.GetHeartbeat(options...),
+		options: options,
 	}
 }
 
 func (s *socket) Listen() (err error) {
-	var fi os.FileInfo
+	if s.err != nil {
+		return s.err
+	}
 	var network = s.getNetwork()
 	addr := s.getUnixSockFile()
-	fi, err = os.Stat(addr)
-	if err != nil && !os.IsNotExist(err) {
-		return log.Errorf(err.Error())
-	}
-	if fi.Name() != "" {
-		if err = os.Remove(addr); err != nil {
-			return log.Errorf("remove file error [%v]", err.Error())
-		}
+	var sf *sockFile
+	if sf, err = prepareSockFile(network, addr, api.GetUnixFile(s.options...)); err != nil {
+		return err
 	}
 
 	var unixAddr *net.UnixAddr
 	unixAddr, err = net.ResolveUnixAddr(network, s.ui.GetPath())
 	if err != nil {
+		sf.unlock()
 		return log.Errorf("resolve unix addr %s error [%s]", s.ui.GetPath(), err.Error())
 	}
-	if s.listener, err = net.ListenUnix("unix", unixAddr); err != nil {
+	var ul *net.UnixListener
+	if ul, err = net.ListenUnix("unix", unixAddr); err != nil {
+		sf.unlock()
 		return log.Errorf("listen tcp address [%s] error [%s]", addr, err.Error())
 	}
+	ul.SetUnlinkOnClose(false) //removed by sockFile only if it is still the one bound
+	s.listener = ul
+	if err = sf.setup(); err != nil {
+		_ = s.listener.Close()
+		sf.remove()
+		return err
+	}
+	s.sf = sf
+	if s.isTLS() {
+		var config *tls.Config
+		if config, err = api.NewTLSConfig(s.ui, true, s.options...); err != nil {
+			_ = s.listener.Close()
+			sf.remove()
+			return log.Errorf("listen tls address [%s] error [%s]", addr, err.Error())
+		}
+		s.listener = tls.NewListener(s.listener, config)
+	}
 	return
 }
 
@@ -63,31 +113,92 @@ func (s *socket) Accept() api.Socket {
 		return nil
 	}
 	return &socket{
-		conn: conn,
-		ui:   s.ui,
+		conn:    conn,
+		ui:      s.ui,
+		framer:  s.framer,
+		hbOpt:   s.hbOpt,
+		options: s.options,
 	}
 }
 
 func (s *socket) Connect() (err error) {
+	return s.ConnectContext(context.Background())
+}
+
+func (s *socket) ConnectContext(ctx context.Context) (err error) {
+	if s.err != nil {
+		return s.err
+	}
 	var network = s.getNetwork()
 	addr := s.getUnixSockFile()
-	var unixAddr *net.UnixAddr
-	unixAddr, err = net.ResolveUnixAddr(network, addr)
-	if err != nil {
-		return log.Errorf("resolve address [%s] error [%s]", addr, err)
+	var config *tls.Config
+	if s.isTLS() {
+		if config, err = api.NewTLSConfig(s.ui, false, s.options...); err != nil {
+			return log.Errorf("dial tls to [%s] error [%s]", addr, err.Error())
+		}
 	}
-
-	s.conn, err = net.DialUnix(network, nil, unixAddr)
+	var dialer net.Dialer
+	s.conn, err = dialer.DialContext(ctx, network, addr)
 	if err != nil {
+		if ctx.Err() != nil {
+			log.Errorf("dial [%s] to [%s] error [%s]", network, addr, err.Error())
+			return api.NewTimeoutError("connect", ctx.Err())
+		}
 		return log.Errorf("dial [%s] to [%s] error [%s]", network, addr, err.Error())
 	}
+	if config != nil {
+		s.conn = tls.Client(s.conn, config)
+		if err = s.Handshake(ctx); err != nil {
+			_ = s.conn.Close()
+			return err
+		}
+	}
+	return
+}
+
+// Handshake run TLS handshake, do nothing if not a TLS connection
+func (s *socket) Handshake(ctx context.Context) (err error) {
+	tc, ok := s.conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	if err = api.RunContext(ctx, "handshake", tc.SetDeadline, tc.Handshake); err != nil {
+		return log.Errorf("tls handshake with [%s] error [%s]", s.GetRemoteAddr(), err.Error())
+	}
+	return
+}
+
+func (s *socket) GetTLSState() (state tls.ConnectionState, ok bool) {
+	var tc *tls.Conn
+	if tc, ok = s.conn.(*tls.Conn); ok {
+		state = tc.ConnectionState()
+	}
 	return
 }
 
 func (s *socket) Send(data []byte, to ...string) (n int, err error) {
 	s.locker.Lock()
 	defer s.locker.Unlock()
-	return s.conn.Write(data)
+	return s.send(data)
+}
+
+// SendContext send with context, NOTE: the connection is closed if the context expired in the middle of a frame (or
+// a TLS write timed out), the peer can not tell the rest of the frame from the next one
+func (s *socket) SendContext(ctx context.Context, data []byte, to ...string) (n int, err error) {
+	if s.conn == nil {
+		return 0, fmt.Errorf("socket is nil")
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	err = api.RunContext(ctx, "send", s.conn.SetWriteDeadline, func() (e error) {
+		n, e = s.send(data)
+		return
+	})
+	if err != nil && (s.wpartial || api.IsTimeout(err) && s.isTLS()) {
+		log.Warnf("write a partial frame to [%s] error [%s], connection closed", s.GetRemoteAddr(), err.Error())
+		_ = s.Close()
+	}
+	return
 }
 
 func (s *socket) SendJson(v interface{}, to ...string) (n int, err error) {
@@ -99,11 +210,32 @@ func (s *socket) SendJson(v interface{}, to ...string) (n int, err error) {
 	return s.Send(data, to...)
 }
 
+// RecvContext receive with context, NOTE: the connection with a framer is closed if the context expired in the middle
+// of a frame, the rest of the frame can not be told from the next one
+func (s *socket) RecvContext(ctx context.Context, length int) (msg *api.SockMessage, err error) {
+	if s.conn == nil {
+		return nil, fmt.Errorf("socket is nil")
+	}
+	err = api.RunContext(ctx, "recv", s.conn.SetReadDeadline, func() (e error) {
+		msg, e = s.Recv(length)
+		return
+	})
+	if err != nil && s.partial {
+		log.Warnf("read a partial frame from [%s] error [%s], connection closed", s.GetRemoteAddr(), err.Error())
+		_ = s.Close()
+	}
+	return
+}
+
 // length <= 0, default PACK_FRAGMENT_MAX=1500 bytes
 func (s *socket) Recv(length int) (msg *api.SockMessage, err error) {
+	if s.framer != nil {
+		return s.recvFrame()
+	}
 
 	var once bool
 	var recv, left int
+	var start = s.nread
 	if length <= 0 {
 		once = true
 		length = types.PACK_FRAGMENT_MAX
@@ -112,14 +244,14 @@ func (s *socket) Recv(length int) (msg *api.SockMessage, err error) {
 	data := s.makeBuffer(length)
 	var n int
 	if once {
-		if n, err = s.conn.Read(data); err != nil {
+		if n, err = s.read(data); err != nil {
 			return nil, log.Errorf("read data from [%s] error [%v]", s.GetRemoteAddr(), err.Error())
 		}
 		recv = n
 	} else {
 
 		for left > 0 {
-			if n, err = s.conn.Read(data[recv:]); err != nil {
+			if n, err = s.read(data[recv:]); err != nil {
 				return nil, log.Errorf("read data from [%s] error [%v]", s.GetRemoteAddr(), err.Error())
 			}
 			left -= n
@@ -130,26 +262,46 @@ func (s *socket) Recv(length int) (msg *api.SockMessage, err error) {
 	if recv < length {
 		data = data[:recv]
 	}
+	files, err := s.takeFiles(start, s.nread)
+	if err != nil {
+		return nil, err
+	}
 	from := s.GetLocalAddr()
 	return &api.SockMessage{
-		Sock: s,
-		Data: data,
-		From: from,
+		Sock:  s,
+		Data:  data,
+		From:  from,
+		Files: files,
 	}, nil
 }
 
 func (s *socket) Close() (err error) {
-	if s.closed {
+	if s.listener == nil && s.conn == nil {
+		return log.Error("socket is nil")
+	}
+	if !s.setClosed() {
 		err = fmt.Errorf("socket already closed")
 		return
 	}
-	if s.conn == nil {
-		return log.Error("socket is nil")
+	if s.listener != nil {
+		defer s.sf.remove()
+		return s.listener.Close()
 	}
-	s.closed = true
+	defer s.closeFiles()
 	return s.conn.Close()
 }
 
+// setClosed mark the socket closed, false returned if closed already (closed by server and handler concurrently)
+func (s *socket) setClosed() bool {
+	s.cLocker.Lock()
+	defer s.cLocker.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closed = true
+	return true
+}
+
 func (s *socket) GetLocalAddr() (strAddr string) {
 	return s.getUnixSockFile()
 }
@@ -162,22 +314,179 @@ func (s *socket) GetSocketType() types.SocketType {
 	return types.SocketType_UNIX
 }
 
+// getUnixSockFile returns the socket file path or abstract name (starts with '@'), validated by NewSocket
 func (s *socket) getUnixSockFile() (strSockFile string) {
-
 	if s.ui == nil {
 		return
 	}
-	strSockFile = s.ui.GetPath()
-	if !strings.HasSuffix(strSockFile, "sock") {
-		panic("unix socket must .sock as file suffix")
-	}
-	return
+	return s.ui.GetPath()
 }
 
 func (s *socket) getNetwork() string {
 	return types.NETWORK_UNIX
 }
 
+func (s *socket) isTLS() bool {
+	return s.ui.GetScheme() == types.URL_SCHEME_UNIX_TLS
+}
+
 func (s *socket) makeBuffer(length int) []byte {
 	return make([]byte, length)
 }
+
+func (s *socket) send(data []byte) (n int, err error) {
+	if s.framer == nil {
+		return s.conn.Write(data)
+	}
+	var frame []byte
+	if frame, err = s.framer.Encode(data); err != nil {
+		return 0, log.Errorf("encode message with framer [%s] error [%s]", s.framer.Name(), err.Error())
+	}
+	var written int
+	if written, err = s.conn.Write(frame); err != nil {
+		s.wpartial = written > 0
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Ping send an application level ping message, a framer is required
+func (s *socket) Ping() (err error) {
+	if s.hbOpt == nil || s.framer == nil {
+		return api.ErrHeartbeatNotSupported //heartbeat requires heartbeat option and framer
+	}
+	s.hbLocker.Lock()
+	s.pingAt = time.Now()
+	s.hbLocker.Unlock()
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	_, err = s.send(s.hbOpt.GetPingData())
+	return
+}
+
+func (s *socket) GetPong() (last time.Time, rtt time.Duration) {
+	s.hbLocker.Lock()
+	defer s.hbLocker.Unlock()
+	return s.pongAt, s.rtt
+}
+
+// onHeartbeat reply pong for ping message and record pong time, true returned if data is a heartbeat message
+func (s *socket) onHeartbeat(data []byte) bool {
+	if s.hbOpt == nil {
+		return false
+	}
+	if bytes.Equal(data, s.hbOpt.GetPingData()) {
+		s.locker.Lock()
+		defer s.locker.Unlock()
+		if _, err := s.send(s.hbOpt.GetPongData()); err != nil {
+			log.Warnf("reply pong to [%s] error [%s]", s.GetRemoteAddr(), err.Error())
+		}
+		return true
+	}
+	if bytes.Equal(data, s.hbOpt.GetPongData()) {
+		s.hbLocker.Lock()
+		defer s.hbLocker.Unlock()
+		s.pongAt = time.Now()
+		s.rtt = s.pongAt.Sub(s.pingAt)
+		return true
+	}
+	return false
+}
+
+// recvFrame read exactly one message by framer, the length parameter of Recv is ignored
+func (s *socket) recvFrame() (msg *api.SockMessage, err error) {
+	var data []byte
+	var start int64
+	r := s.getReader()
+	for {
+		start = s.consumed()
+		if data, err = s.framer.Decode(r); err != nil {
+			s.partial = s.consumed() != start
+			break
+		}
+		if !s.onHeartbeat(data) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, log.Errorf("read data from [%s] error [%v]", s.GetRemoteAddr(), err.Error())
+	}
+	files, err := s.takeFiles(start, s.consumed())
+	if err != nil {
+		return nil, err
+	}
+	return &api.SockMessage{
+		Sock:  s,
+		Data:  data,
+		From:  s.GetLocalAddr(),
+		Files: files,
+	}, nil
+}
+
+func (s *socket) getReader() *bufio.Reader {
+	if s.reader == nil {
+		s.reader = bufio.NewReader(readFunc(s.read))
+	}
+	return s.reader
+}
+
+// consumed returns the bytes taken from framer reader
+func (s *socket) consumed() int64 {
+	return s.nread - int64(s.reader.Buffered())
+}
+
+// readFunc adapts a read function to io.Reader
+type readFunc func(p []byte) (n int, err error)
+
+func (f readFunc) Read(p []byte) (n int, err error) {
+	return f(p)
+}
+
+// rights files received with the data at stream offset
+type rights struct {
+	at    int64      //stream offset of the last byte read with the files
+	files []*os.File //files received
+	err   error      //files lost (control message truncated or malformed)
+}
+
+// takeFiles returns the files received with the data in stream range [start, end) of a message, the files of the data
+// before start (eg. a heartbeat or a frame failed) are closed. The files are closed and an error wrapping
+// api.ErrBadMessage returned if some files of the message lost, the message is dropped and the socket is still usable
+func (s *socket) takeFiles(start, end int64) (files []*os.File, err error) {
+	s.fLocker.Lock()
+	defer s.fLocker.Unlock()
+	var i int
+	for ; i < len(s.files) && s.files[i].at < end; i++ {
+		r := s.files[i]
+		if r.at < start {
+			closeAll(r.files)
+			continue
+		}
+		files = append(files, r.files...)
+		if r.err != nil {
+			err = r.err
+		}
+	}
+	s.files = s.files[i:]
+	if err != nil {
+		closeAll(files)
+		return nil, fmt.Errorf("%w: %s", api.ErrBadMessage, err.Error())
+	}
+	return
+}
+
+// closeFiles close the files received and not returned
+func (s *socket) closeFiles() {
+	s.fLocker.Lock()
+	defer s.fLocker.Unlock()
+	for _, r := range s.files {
+		closeAll(r.files)
+	}
+	s.files = nil
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
@@ -0,0 +1,200 @@
+package unixsock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/civet148/gotools/parser"
+	"github.com/civet148/log"
+	"github.com/civet148/socketx/api"
+	"github.com/civet148/socketx/types"
+	"net"
+	"strings"
+	"sync"
+)
+
+// gramSocket UNIX datagram socket, send to and receive from other datagram sockets by address like UDP
+type gramSocket struct {
+	ui      *parser.UrlInfo
+	conn    *net.UnixConn
+	closed  bool
+	locker  sync.Mutex
+	err     error              //socket address error
+	options []api.SocketOption //socket options
+	sf      *sockFile          //socket file, removed when closed
+}
+
+func init() {
+	_ = api.Register(types.SocketType_UNIXGRAM, NewGramSocket)
+}
+
+func NewGramSocket(ui *parser.UrlInfo, options ...api.SocketOption) api.Socket {
+	_, err := getUnixAddr(ui)
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+	return &gramSocket{
+		ui:      ui,
+		err:     err,
+		options: options,
+	}
+}
+
+// Listen bind the socket file path or abstract name to send and receive datagrams
+func (s *gramSocket) Listen() (err error) {
+	if s.err != nil {
+		return s.err
+	}
+	addr := s.getUnixSockFile()
+	var sf *sockFile
+	if sf, err = prepareSockFile(types.NETWORK_UNIXGRAM, addr, api.GetUnixFile(s.options...)); err != nil {
+		return err
+	}
+	var unixAddr *net.UnixAddr
+	if unixAddr, err = net.ResolveUnixAddr(types.NETWORK_UNIXGRAM, addr); err != nil {
+		sf.unlock()
+		return log.Errorf("resolve unixgram addr %s error [%s]", addr, err.Error())
+	}
+	if s.conn, err = net.ListenUnixgram(types.NETWORK_UNIXGRAM, unixAddr); err != nil {
+		sf.unlock()
+		return log.Errorf("listen unixgram address [%s] error [%s]", addr, err.Error())
+	}
+	if err = sf.setup(); err != nil {
+		_ = s.conn.Close()
+		sf.remove()
+		return err
+	}
+	s.sf = sf
+	return
+}
+
+func (s *gramSocket) Accept() api.Socket {
+	log.Warnf("accept method only for UNIX stream socket")
+	return nil
+}
+
+func (s *gramSocket) Connect() (err error) {
+	return fmt.Errorf("only for UNIX stream socket, listen a unixgram address to send and receive")
+}
+
+func (s *gramSocket) ConnectContext(ctx context.Context) (err error) {
+	return s.Connect()
+}
+
+// Send send a datagram to the socket file path or abstract name, eg. /tmp/server.sock, @myservice or unixgram:///tmp/server.sock
+func (s *gramSocket) Send(data []byte, to ...string) (n int, err error) {
+	if s.conn == nil {
+		return 0, fmt.Errorf("socket is nil")
+	}
+	if len(to) == 0 {
+		return 0, fmt.Errorf("unixgram send method to parameter required")
+	}
+	strToAddr := to[0]
+	if nIndex := strings.Index(strToAddr, parser.URL_SCHEME_SEP); nIndex >= 0 {
+		strToAddr = strToAddr[nIndex+len(parser.URL_SCHEME_SEP):]
+	}
+	if err = checkUnixAddr(strToAddr); err != nil {
+		return 0, log.Errorf(err.Error())
+	}
+	var unixAddr *net.UnixAddr
+	if unixAddr, err = net.ResolveUnixAddr(types.NETWORK_UNIXGRAM, strToAddr); err != nil {
+		return 0, log.Errorf("resolve unixgram addr [%v] error [%v]", strToAddr, err.Error())
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.conn.WriteToUnix(data, unixAddr)
+}
+
+func (s *gramSocket) SendContext(ctx context.Context, data []byte, to ...string) (n int, err error) {
+	if s.conn == nil {
+		return 0, fmt.Errorf("socket is nil")
+	}
+	err = api.RunContext(ctx, "send", s.conn.SetWriteDeadline, func() (e error) {
+		n, e = s.Send(data, to...)
+		return
+	})
+	return
+}
+
+func (s *gramSocket) SendJson(v interface{}, to ...string) (n int, err error) {
+	var data []byte
+	data, err = json.Marshal(v)
+	if err != nil {
+		return 0, log.Errorf(err.Error())
+	}
+	return s.Send(data, to...)
+}
+
+// Recv receive a datagram, length is the receive buffer size, default PACK_FRAGMENT_MAX=1500 bytes. The From field is
+// the address of sender, empty if the sender is not bound. An error wraps api.ErrMessageTooLarge returned if the
+// datagram exceeds the receive buffer, the datagram is dropped and the socket is still usable.
+func (s *gramSocket) Recv(length int) (msg *api.SockMessage, err error) {
+	if s.conn == nil {
+		return nil, fmt.Errorf("socket is nil")
+	}
+	if length <= 0 {
+		length = types.PACK_FRAGMENT_MAX
+	}
+	data := make([]byte, length+1) //one more byte to detect oversize datagram
+	var n int
+	var unixAddr *net.UnixAddr
+	if n, unixAddr, err = s.conn.ReadFromUnix(data); err != nil {
+		return nil, log.Errorf("read from unixgram error [%v]", err.Error())
+	}
+	var from string
+	if unixAddr != nil {
+		from = unixAddr.Name
+	}
+	if n > length {
+		err = fmt.Errorf("%w: datagram from [%s] exceeds receive buffer [%d] bytes", api.ErrMessageTooLarge, from, length)
+		log.Warnf(err.Error())
+		return nil, err
+	}
+	return &api.SockMessage{
+		Sock: s,
+		Data: data[:n],
+		From: from,
+	}, nil
+}
+
+func (s *gramSocket) RecvContext(ctx context.Context, length int) (msg *api.SockMessage, err error) {
+	if s.conn == nil {
+		return nil, fmt.Errorf("socket is nil")
+	}
+	err = api.RunContext(ctx, "recv", s.conn.SetReadDeadline, func() (e error) {
+		msg, e = s.Recv(length)
+		return
+	})
+	return
+}
+
+func (s *gramSocket) Close() (err error) {
+	if s.closed {
+		return fmt.Errorf("socket already closed")
+	}
+	if s.conn == nil {
+		return fmt.Errorf("socket is nil")
+	}
+	s.closed = true
+	defer s.sf.remove()
+	return s.conn.Close()
+}
+
+func (s *gramSocket) GetLocalAddr() string {
+	return s.getUnixSockFile()
+}
+
+func (s *gramSocket) GetRemoteAddr() (addr string) {
+	return
+}
+
+func (s *gramSocket) GetSocketType() types.SocketType {
+	return types.SocketType_UNIXGRAM
+}
+
+func (s *gramSocket) getUnixSockFile() string {
+	if s.ui == nil {
+		return ""
+	}
+	return s.ui.GetPath()
+}
@@ -1,6 +1,7 @@
 package websock
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -10,17 +11,27 @@ import (
 	"github.com/civet148/socketx/types"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 )
 
 type socket struct {
 	ui        *parser.UrlInfo
 	conn      *websocket.Conn
 	accepting chan *websocket.Conn
+	quit      chan bool    //closed when server socket closed
+	server    *http.Server //http server for listening
+	listening bool         //server socket, listening by itself or mounted on an external http server
 	closed    bool
+	cLocker   sync.Mutex //close locker
 	locker    sync.RWMutex
 	option    *api.SocketOption
+	hbLocker  sync.Mutex    //heartbeat locker
+	pongAt    time.Time     //last pong received time
+	rtt       time.Duration //last round trip time
 }
 
 func init() {
@@ -36,28 +47,52 @@ func NewSocket(ui *parser.UrlInfo, options ...api.SocketOption) api.Socket {
 		ui:        ui,
 		option:    option,
 		accepting: make(chan *websocket.Conn, 1000),
+		quit:      make(chan bool),
 	}
 }
 
 func (s *socket) Listen() (err error) {
-	engine := gin.Default()
 	if s.ui.GetPath() == "" {
 		s.ui.Path = "/"
 	}
+	s.listening = true
+	if s.option != nil {
+		switch {
+		case s.option.GinRouter != nil:
+			s.option.GinRouter.GET(s.ui.Path, s.webSocketRegister)
+			return
+		case s.option.ServeMux != nil:
+			s.option.ServeMux.Handle(s.ui.Path, s)
+			return
+		case s.option.Detached:
+			return
+		}
+	}
 
+	engine := gin.Default()
 	engine.GET(s.ui.Path, s.webSocketRegister)
-	strCertFile := s.ui.Queries[types.WSS_TLS_CERT]
-	strKeyFile := s.ui.Queries[types.WSS_TLS_KEY]
+	var config *tls.Config
+	if s.ui.Scheme == types.URL_SCHEME_WSS {
+		if config, err = api.NewTLSConfig(s.ui, true, s.getOptions()...); err != nil {
+			return log.Errorf("listen wss address [%s] error [%s]", s.ui.Host, err.Error())
+		}
+	}
 
+	var listener net.Listener
+	var lc = net.ListenConfig{KeepAlive: s.getKeepAlive()}
+	if listener, err = lc.Listen(context.Background(), types.NETWORK_TCP, s.ui.Host); err != nil {
+		return log.Errorf("listen websocket address [%s] error [%s]", s.ui.Host, err.Error())
+	}
+	s.server = &http.Server{Handler: engine, TLSConfig: config}
 	go func() {
+		var err error
 		if s.ui.Scheme == types.URL_SCHEME_WSS {
-			err = engine.RunTLS(s.ui.Host, strCertFile, strKeyFile)
+			err = s.server.ServeTLS(listener, "", "")
 		} else {
-			err = engine.Run(s.ui.Host)
+			err = s.server.Serve(listener)
 		}
 
-		if err != nil {
-			s.closed = true
+		if err != nil && err != http.ErrServerClosed {
 			log.Errorf("listen websocket closing with error [%v]", err.Error())
 			return
 		}
@@ -69,35 +104,48 @@ func (s *socket) Accept() api.Socket {
 
 	var c *websocket.Conn
 	select {
+	case <-s.quit:
+		return nil
 	case c = <-s.accepting:
 		{
-			c.SetCloseHandler(s.webSocketCloseHandler)
-			c.SetPingHandler(s.websocketPingHandler)
-			c.SetPongHandler(s.websocketPongHandler)
-			return &socket{
-				conn: c,
-				ui:   s.ui,
+			sock := &socket{
+				conn:   c,
+				ui:     s.ui,
+				option: s.option,
 			}
+			sock.setHandlers()
+			return sock
 		}
 	}
-	return nil
 }
 
 func (s *socket) Connect() (err error) {
+	return s.ConnectContext(context.Background())
+}
+
+func (s *socket) ConnectContext(ctx context.Context) (err error) {
 	url := fmt.Sprintf("%v://%v%v", s.ui.Scheme, s.ui.Host, s.ui.Path)
 	dialer := &websocket.Dialer{}
+	netDialer := &net.Dialer{KeepAlive: s.getKeepAlive()}
+	dialer.NetDialContext = netDialer.DialContext
 	if s.ui.Scheme == types.URL_SCHEME_WSS {
-		dialer.TLSClientConfig = &tls.Config{RootCAs: nil, InsecureSkipVerify: true}
+		if dialer.TLSClientConfig, err = api.NewTLSConfig(s.ui, false, s.getOptions()...); err != nil {
+			return log.Errorf("dial wss to [%s] error [%s]", url, err.Error())
+		}
 	}
 	var header http.Header
 	if s.option != nil {
 		header = s.option.Header
 	}
 	log.Infof("connecting to [%s] with header [%+v]", url, header)
-	if s.conn, _, err = dialer.Dial(url, header); err != nil {
+	if s.conn, _, err = dialer.DialContext(ctx, url, header); err != nil {
 		log.Errorf(err.Error())
+		if ctx.Err() != nil {
+			return api.NewTimeoutError("connect", ctx.Err())
+		}
 		return
 	}
+	s.setHandlers()
 	return
 }
 
@@ -108,10 +156,44 @@ func (s *socket) Send(data []byte, to ...string) (n int, err error) {
 	}
 	s.locker.Lock()
 	defer s.locker.Unlock()
-	if err = s.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+	return s.send(websocket.BinaryMessage, data)
+}
+
+// SendMessage send data as text or binary message, msgType 0 means binary
+func (s *socket) SendMessage(msgType int, data []byte) (n int, err error) {
+	if s.conn == nil {
+		err = fmt.Errorf("web socket connection is nil")
 		return
 	}
-	n = len(data)
+	switch msgType {
+	case 0:
+		msgType = websocket.BinaryMessage
+	case websocket.TextMessage, websocket.BinaryMessage:
+	default:
+		return 0, fmt.Errorf("unsupported web socket message type [%d]", msgType)
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.send(msgType, data)
+}
+
+// SendContext send with context, NOTE: the connection is closed if the context expired, a web socket connection can
+// not be written any more after a write timeout
+func (s *socket) SendContext(ctx context.Context, data []byte, to ...string) (n int, err error) {
+	if s.conn == nil {
+		err = fmt.Errorf("web socket connection is nil")
+		return
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	err = api.RunContext(ctx, "send", s.conn.SetWriteDeadline, func() (e error) {
+		n, e = s.send(websocket.BinaryMessage, data)
+		return
+	})
+	if err != nil && api.IsTimeout(err) {
+		log.Warnf("write to [%s] error [%s], connection closed", s.GetRemoteAddr(), err.Error())
+		_ = s.Close()
+	}
 	return
 }
 
@@ -121,7 +203,19 @@ func (s *socket) SendJson(v interface{}, to ...string) (n int, err error) {
 	if err != nil {
 		return 0, log.Errorf(err.Error())
 	}
-	return s.Send(data, to...)
+	return s.SendMessage(websocket.TextMessage, data)
+}
+
+// RecvContext receive with context, NOTE: the web socket connection can not be used any more after a read timeout
+func (s *socket) RecvContext(ctx context.Context, length int) (msg *api.SockMessage, err error) {
+	if s.conn == nil {
+		return nil, log.Errorf("web socket connection is nil")
+	}
+	err = api.RunContext(ctx, "recv", s.conn.SetReadDeadline, func() (e error) {
+		msg, e = s.Recv(length)
+		return
+	})
+	return
 }
 
 func (s *socket) Recv(length int) (msg *api.SockMessage, err error) {
@@ -145,17 +239,40 @@ func (s *socket) Recv(length int) (msg *api.SockMessage, err error) {
 
 func (s *socket) Close() (err error) {
 
-	if s.closed {
+	if !s.setClosed() {
 		return fmt.Errorf("socket already closed")
 	}
-	s.closed = true
+	if s.listening {
+		close(s.quit)
+		if s.server != nil {
+			err = s.server.Close()
+		}
+		for {
+			select {
+			case c := <-s.accepting: //upgraded but not accepted yet
+				_ = c.Close()
+			default:
+				return
+			}
+		}
+	}
 	if s.conn == nil {
 		return fmt.Errorf("socket is nil")
 	}
-	s.closed = true
 	return s.conn.Close()
 }
 
+// setClosed mark the socket closed, false returned if closed already (closed by server and handler concurrently)
+func (s *socket) setClosed() bool {
+	s.cLocker.Lock()
+	defer s.cLocker.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closed = true
+	return true
+}
+
 func (s *socket) GetLocalAddr() (addr string) {
 	if s.conn == nil {
 		return s.ui.Host //web socket server connection is nil
@@ -176,6 +293,14 @@ func (s *socket) GetSocketType() types.SocketType {
 	return types.SocketType_WEB
 }
 
+func (s *socket) send(msgType int, data []byte) (n int, err error) {
+	if err = s.conn.WriteMessage(msgType, data); err != nil {
+		return
+	}
+	n = len(data)
+	return
+}
+
 func (s *socket) debugMessageType(msgType int) {
 
 	switch msgType {
@@ -193,20 +318,35 @@ func (s *socket) debugMessageType(msgType int) {
 }
 
 func (s *socket) webSocketRegister(ctx *gin.Context) {
+	s.ServeHTTP(ctx.Writer, ctx.Request)
+}
+
+// ServeHTTP upgrade the http request to web socket and feed the connection to Accept
+func (s *socket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var err error
+	select {
+	case <-s.quit:
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	default:
+	}
 	upGrader := &websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true
 		},
-		Subprotocols: []string{ctx.GetHeader("Sec-WebSocket-Protocol")},
+		Subprotocols: []string{r.Header.Get("Sec-WebSocket-Protocol")},
 	}
 	var c *websocket.Conn
-	if c, err = upGrader.Upgrade(ctx.Writer, ctx.Request, nil); err != nil {
+	if c, err = upGrader.Upgrade(w, r, nil); err != nil {
 		log.Errorf(err.Error())
 		return
 	}
 	//log.Debugf("client [%v] registered", c.RemoteAddr().String())
-	s.accepting <- c
+	select {
+	case <-s.quit:
+		_ = c.Close()
+	case s.accepting <- c:
+	}
 }
 
 func (s *socket) webSocketCloseHandler(code int, text string) (err error) {
@@ -216,10 +356,56 @@ func (s *socket) webSocketCloseHandler(code int, text string) (err error) {
 
 func (s *socket) websocketPingHandler(appData string) (err error) {
 	log.Debugf("ping app data [%v]", appData)
+	err = s.conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(types.WS_CONTROL_TIMEOUT))
+	if err == websocket.ErrCloseSent {
+		return nil
+	}
 	return
 }
 
 func (s *socket) websocketPongHandler(appData string) (err error) {
 	log.Debugf("pong app data [%v]", appData)
+	now := time.Now()
+	s.hbLocker.Lock()
+	defer s.hbLocker.Unlock()
+	s.pongAt = now
+	if nano, e := strconv.ParseInt(appData, 10, 64); e == nil {
+		s.rtt = now.Sub(time.Unix(0, nano))
+	}
 	return
 }
+
+// Ping send a native ping frame with the current time as application data
+func (s *socket) Ping() (err error) {
+	if s.conn == nil {
+		return fmt.Errorf("web socket connection is nil")
+	}
+	data := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return s.conn.WriteControl(websocket.PingMessage, []byte(data), time.Now().Add(types.WS_CONTROL_TIMEOUT))
+}
+
+func (s *socket) GetPong() (last time.Time, rtt time.Duration) {
+	s.hbLocker.Lock()
+	defer s.hbLocker.Unlock()
+	return s.pongAt, s.rtt
+}
+
+func (s *socket) setHandlers() {
+	s.conn.SetCloseHandler(s.webSocketCloseHandler)
+	s.conn.SetPingHandler(s.websocketPingHandler)
+	s.conn.SetPongHandler(s.websocketPongHandler)
+}
+
+func (s *socket) getOptions() (options []api.SocketOption) {
+	if s.option != nil {
+		options = append(options, *s.option)
+	}
+	return
+}
+
+func (s *socket) getKeepAlive() time.Duration {
+	if s.option == nil || s.option.Heartbeat == nil {
+		return 0
+	}
+	return s.option.Heartbeat.KeepAlive
+}